@@ -7,6 +7,7 @@ import (
 	"os/signal"
 
 	"github.com/nokia/dynamic-local-pv-provisioner/pkg/handlers"
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
 	syscall "golang.org/x/sys/unix"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
@@ -20,6 +21,11 @@ const (
 var (
 	kubeConfig  string
 	storagePath string
+	device      string
+	forceFormat bool
+
+	provisionerName  string
+	annotationDomain string
 )
 
 type Executor struct {
@@ -28,6 +34,15 @@ type Executor struct {
 
 func main() {
 	flag.Parse()
+	if err := k8sclient.Configure(provisionerName, annotationDomain); err != nil {
+		log.Fatal("ERROR: " + err.Error() + ", exiting!")
+	}
+	if device != "" {
+		err := handlers.SetupStoragePool(device, storagePath, forceFormat)
+		if err != nil {
+			log.Fatal("ERROR: Could not set up storage pool on device " + device + " because of error: " + err.Error() + ", exiting!")
+		}
+	}
 	executor := Executor{
 		Controllers: make(map[string]cache.Controller),
 	}
@@ -35,27 +50,47 @@ func main() {
 	if err != nil {
 		log.Fatal("ERROR: Parsing kubeconfig failed with error: " + err.Error() + ", exiting!")
 	}
-	pvcHandler, err := handlers.NewPvcHandler(storagePath, cfg)
+	k8sclient.SetConfig(cfg)
+	pvcHandler, err := handlers.NewPvcHandler(storagePath)
 	if err != nil {
 		log.Fatal("ERROR: Could not initalize K8s client for PvcHandler because of error: " + err.Error() + ", exiting!")
 	}
-	pvcController := pvcHandler.CreateController()
+	pvcController, err := pvcHandler.CreateController()
+	if err != nil {
+		log.Fatal("ERROR: Could not create PvcHandler controller because of error: " + err.Error() + ", exiting!")
+	}
 	executor.Controllers[PvcController] = pvcController
 
-	pvHandler, err := handlers.NewPvHandler(storagePath, cfg)
+	pvHandler, err := handlers.NewPvHandler(storagePath)
 	if err != nil {
 		log.Fatal("ERROR: Could not initalize K8s client for PvHandler because of error: " + err.Error() + ", exiting!")
 	}
-	pvController := pvHandler.CreateController()
+	pvController, err := pvHandler.CreateController()
+	if err != nil {
+		log.Fatal("ERROR: Could not create PvHandler controller because of error: " + err.Error() + ", exiting!")
+	}
 	executor.Controllers[PvController] = pvController
 
+	heartbeat, err := handlers.NewHeartbeat()
+	if err != nil {
+		log.Fatal("ERROR: Could not initalize K8s client for Heartbeat because of error: " + err.Error() + ", exiting!")
+	}
+
 	stopChannel := make(chan struct{})
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
 	log.Println("Storage controller initalized successfully! Warm-up starts now!")
-	for _, controller := range executor.Controllers {
-		go controller.Run(stopChannel)
+	// the controllers are informers of the shared factory, they are started together with the caches
+	err = k8sclient.StartInformers(stopChannel)
+	if err != nil {
+		log.Fatal("ERROR: Could not start informers because of error: " + err.Error() + ", exiting!")
+	}
+	for name, controller := range executor.Controllers {
+		if !cache.WaitForCacheSync(stopChannel, controller.HasSynced) {
+			log.Fatal("ERROR: Controller " + name + " could not sync, exiting!")
+		}
 	}
+	go heartbeat.Run(stopChannel)
 	// Wait until Controller pushes a signal on the stop channel
 	select {
 	case <-stopChannel:
@@ -67,5 +102,9 @@ func main() {
 
 func init() {
 	flag.StringVar(&storagePath, "storagepath", "", "The path where VG is mounted and where sig-storage-controller is watching. Mandatory parameter.")
+	flag.StringVar(&device, "device", "", "Block device path or glob pattern matching exactly one device, used to create the storage pool mounted on --storagepath. Optional parameter, only required if the pool is not prepared by the admin.")
+	flag.BoolVar(&forceFormat, "force-format", false, "Format --device even if it already contains a filesystem. Optional parameter, default is false.")
+	flag.StringVar(&provisionerName, "provisioner-name", k8sclient.DefaultProvisionerName, "provisioner name of the StorageClasses handled by this instance. Optional parameter, default is \""+k8sclient.DefaultProvisionerName+"\"")
+	flag.StringVar(&annotationDomain, "annotation-domain", k8sclient.DefaultAnnotationDomain, "domain of the annotations and node resources of this instance, it has to differ between instances sharing a cluster. Optional parameter, default is \""+k8sclient.DefaultAnnotationDomain+"\"")
 	flag.StringVar(&kubeConfig, "kubeconfig", "", "Path to a kubeconfig. Optional parameter, only required if out-of-cluster.")
 }
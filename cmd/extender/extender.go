@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/extender"
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+)
+
+func main() {
+	address := flag.String("listen-address", ":8888", "address the scheduler extender listens on. Optional parameter, default is \":8888\"")
+	provisionerName := flag.String("provisioner-name", k8sclient.DefaultProvisionerName, "provisioner name of the StorageClasses handled by this instance. Optional parameter, default is \""+k8sclient.DefaultProvisionerName+"\"")
+	annotationDomain := flag.String("annotation-domain", k8sclient.DefaultAnnotationDomain, "domain of the annotations and node resources of this instance, it has to differ between instances sharing a cluster. Optional parameter, default is \""+k8sclient.DefaultAnnotationDomain+"\"")
+	flag.Parse()
+	if err := k8sclient.Configure(*provisionerName, *annotationDomain); err != nil {
+		log.Fatalln("ERROR: " + err.Error())
+	}
+	stopChannel := make(chan struct{})
+	err := k8sclient.StartInformers(stopChannel)
+	if err != nil {
+		log.Fatalln("ERROR: Cannot start informers, because: " + err.Error())
+	}
+	ext := extender.NewExtender()
+
+	http.HandleFunc("/filter", ext.ServeFilter)
+	http.HandleFunc("/prioritize", ext.ServePrioritize)
+	server := &http.Server{
+		Addr:         *address,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+	log.Println("INFO:DLPP scheduler extender is about to start listening on " + *address)
+	err = server.ListenAndServe()
+	log.Fatal(err)
+}
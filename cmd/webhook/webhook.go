@@ -1,46 +1,190 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/certmanager"
 	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/metrics"
 	"github.com/nokia/dynamic-local-pv-provisioner/pkg/mutator"
+	syscall "golang.org/x/sys/unix"
 )
 
-var nodeSelectMethod string
+var (
+	nodeSelectMethod   string
+	listenAddress      string
+	shutdownTimeout    time.Duration
+	shutdownDelay      time.Duration
+	deferredPlacement  bool
+	replacementTimeout time.Duration
+	trustedUsers       []string
+)
 
 func main() {
-	cert := flag.String("tls-cert-bundle", "", "file containing the x509 Certificate for HTTPS. (CA cert, if any, concatenated after server cert).")
+	cert := flag.String("tls-cert-bundle", "", "file containing the x509 Certificate for HTTPS. (CA cert, if any, concatenated after server cert). The file is reloaded when it changes.")
 	key := flag.String("tls-private-key-file", "", "file containing the x509 private key matching --tls-cert-bundle.")
+	certSecret := flag.String("self-signed-cert-secret", "", "name of the Secret holding a self-signed CA and serving certificate generated and renewed by the webhook. Optional parameter, replaces --tls-cert-bundle and --tls-private-key-file.")
+	serviceName := flag.String("service-name", "dynamic-local-pv-provisioner", "name of the Service of the webhook in its namespace, used for the self-signed certificate and the webhook registration.")
+	webhookConfiguration := flag.String("webhook-configuration-name", "", "name of the MutatingWebhookConfiguration and ValidatingWebhookConfiguration the webhook creates and keeps updated with its CA bundle. Optional parameter, registration is disabled if empty.")
 	nodeLabel := flag.String("node-label-for-dynamic", "", " node label for dynamic local pv provisoner. Optional parameter, only required when local-storage not configured on all nodes.")
-	flag.StringVar(&nodeSelectMethod, "node-selector-method", "round robin", "node selector method. Acceptable values: \"round robin\" or \"capacity\", default is \"round robin\"")
+	flag.StringVar(&nodeSelectMethod, "node-selector-method", k8sclient.RR, "default node selector method, can be overridden by the \""+k8sclient.NodeSelectorMethodParameter+"\" StorageClass parameter. Acceptable values: \"round robin\", \"capacity\", \"least-allocated\", \"most-allocated\", \"random\" or \"weighted-capacity\", default is \"round robin\"")
+	flag.DurationVar(&k8sclient.ExecutorHeartbeatTimeout, "executor-heartbeat-timeout", k8sclient.ExecutorHeartbeatTimeout, "nodes whose executor did not renew its heartbeat for this duration are not selected. Optional parameter, 0 disables the check, default is 40s")
+	flag.StringVar(&listenAddress, "listen-address", ":443", "address the HTTPS server of the webhook listens on, default is \":443\"")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "time given to in-flight requests to finish after SIGTERM, default is 30s")
+	flag.DurationVar(&shutdownDelay, "shutdown-delay", 5*time.Second, "time between failing /readyz and closing the listener after SIGTERM, so the endpoints are removed before new connections are refused, default is 5s")
+	flag.BoolVar(&deferredPlacement, "deferred-placement", false, "admit local pvcs no node can be selected for yet and place them in the background once a node fits, instead of denying them. Optional parameter, default is false")
+	flag.DurationVar(&replacementTimeout, "replacement-timeout", 0, "unbound pvcs which could not be provisioned on their node for this duration are deleted and created again on another node. Optional parameter, 0 disables re-placement, default is 0")
+	trustedUsersFlag := flag.String("trusted-users", "", "comma separated list of users allowed to change the node assignment of existing pvcs, e.g. system:serviceaccount:kube-system:dynamic-pv")
+	provisionerName := flag.String("provisioner-name", k8sclient.DefaultProvisionerName, "provisioner name of the StorageClasses handled by this instance. Optional parameter, default is \""+k8sclient.DefaultProvisionerName+"\"")
+	annotationDomain := flag.String("annotation-domain", k8sclient.DefaultAnnotationDomain, "domain of the annotations and node resources of this instance, it has to differ between instances sharing a cluster. Optional parameter, default is \""+k8sclient.DefaultAnnotationDomain+"\"")
 	flag.Parse()
-	if nodeSelectMethod != k8sclient.RR && nodeSelectMethod != k8sclient.Cap {
-		log.Fatalln("ERROR: Unacceptable node-selector-method! Acceptable values: \"round robin\" or \"capacity\", default is \"round robin\"")
+	if err := k8sclient.Configure(*provisionerName, *annotationDomain); err != nil {
+		log.Fatalln("ERROR: " + err.Error())
 	}
-	mutate, err := mutator.NewMutator(nodeSelectMethod, *nodeLabel)
-	if cert == nil || key == nil {
-		log.Fatalln("ERROR: Configuring TLS is mandatory, --tls-cert-bundle and --tls-private-key-file cannot be empty!")
-		return
+	if *trustedUsersFlag != "" {
+		trustedUsers = strings.Split(*trustedUsersFlag, ",")
 	}
-	tlsConf, err := tls.LoadX509KeyPair(*cert, *key)
+	stopChannel := make(chan struct{})
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
+	certSource, err := newCertificateSource(*cert, *key, *certSecret, *serviceName, *webhookConfiguration, stopChannel)
 	if err != nil {
-		log.Println("ERROR: TLS configuration could not be initialized, because:" + err.Error())
-		return
+		log.Fatalln("ERROR: TLS configuration could not be initialized, because:" + err.Error())
 	}
-
-	http.HandleFunc("/mutating-pvc", mutate.ServeMutatePvc)
+	// the server starts before the caches are synced, so the probes can be answered, /readyz fails until the webhook is ready
+	var ready int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&ready) == 0 {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.Handle("/metrics", metrics.Handler())
 	server := &http.Server{
-		Addr:         ":443",
-		TLSConfig:    &tls.Config{Certificates: []tls.Certificate{tlsConf}},
+		Addr:         listenAddress,
+		Handler:      mux,
+		TLSConfig:    &tls.Config{GetCertificate: certSource.GetCertificate},
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 	}
-	log.Println("INFO:DLPP webhook is about to start listening on :443")
-	err = server.ListenAndServeTLS("", "")
-	log.Fatal(err)
+	serverErrors := make(chan error, 1)
+	log.Println("INFO:DLPP webhook is about to start listening on " + listenAddress)
+	go func() {
+		serverErrors <- server.ListenAndServeTLS("", "")
+	}()
+
+	err = k8sclient.StartInformers(stopChannel)
+	if err != nil {
+		log.Fatalln("ERROR: Cannot start informers, because: " + err.Error())
+	}
+	err = k8sclient.WatchStatefulSets(stopChannel)
+	if err != nil {
+		log.Println("WARNING: Cannot watch StatefulSets, placement runs without StatefulSet awareness, because: " + err.Error())
+	}
+	if k8sclient.ExecutorHeartbeatTimeout > 0 {
+		err = k8sclient.WatchExecutorHeartbeats(stopChannel)
+		if err != nil {
+			log.Fatalln("ERROR: Cannot watch executor heartbeats, because: " + err.Error())
+		}
+	}
+	policiesWatched, err := k8sclient.WatchLocalStoragePolicies(stopChannel)
+	if err != nil {
+		log.Fatalln("ERROR: Cannot watch LocalStoragePolicies, because: " + err.Error())
+	}
+	if !policiesWatched {
+		log.Println("INFO: LocalStoragePolicy CRD is not installed, namespace policies are not enforced")
+	}
+	mutate, err := mutator.NewMutator(nodeSelectMethod, *nodeLabel, deferredPlacement, trustedUsers)
+	if err != nil {
+		log.Fatalln("ERROR: Unacceptable node-selector-method! " + err.Error())
+	}
+	go mutator.WatchConfig(stopChannel)
+	if replacementTimeout > 0 {
+		err = k8sclient.RunLeaderElected(mutator.ReplacementLock, stopChannel, mutator.NewReplacer(mutate, replacementTimeout).Run)
+		if err != nil {
+			log.Fatalln("ERROR: Cannot start re-placement of failed pvcs, because: " + err.Error())
+		}
+	}
+	if deferredPlacement {
+		err = k8sclient.RunLeaderElected(mutator.DeferredPlacementLock, stopChannel, mutator.NewDeferredPlacer(mutate).Run)
+		if err != nil {
+			log.Fatalln("ERROR: Cannot start deferred placement, because: " + err.Error())
+		}
+	}
+	if policiesWatched {
+		err = k8sclient.RunLeaderElected(mutator.PolicyStatusLock, stopChannel, mutator.NewPolicyStatusReporter().Run)
+		if err != nil {
+			log.Fatalln("ERROR: Cannot start reporting LocalStoragePolicy usage, because: " + err.Error())
+		}
+	}
+
+	mux.HandleFunc("/mutating-pvc", mutate.ServeMutatePvc)
+	mux.HandleFunc("/mutating-pod", mutate.ServeMutatePod)
+	mux.HandleFunc("/validating-pvc", mutate.ServeValidatePvc)
+	mux.HandleFunc("/explain", mutate.ServeExplain)
+	atomic.StoreInt32(&ready, 1)
+	log.Println("INFO: DLPP webhook is ready")
+
+	select {
+	case err = <-serverErrors:
+		log.Fatalln("ERROR: DLPP webhook server stopped, because: " + err.Error())
+	case <-signalChannel:
+		log.Println("INFO: Graceful shutdown initiated, finishing in-flight requests")
+	}
+	atomic.StoreInt32(&ready, 0)
+	time.Sleep(shutdownDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = server.Shutdown(ctx); err != nil {
+		log.Println("ERROR: DLPP webhook could not shut down gracefully, because: " + err.Error())
+	}
+	close(stopChannel)
+	k8sclient.WaitForLeaderElections()
+	log.Println("INFO: DLPP webhook stopped")
+}
+
+// newCertificateSource serves the configured certificate files, or the self-signed certificate of the Secret,
+// and keeps the webhook configurations trusting its CA if the registration is enabled
+func newCertificateSource(cert string, key string, certSecret string, serviceName string, webhookConfiguration string, stopCh <-chan struct{}) (certmanager.CertificateSource, error) {
+	clientSet, err := k8sclient.GetClientSet()
+	if err != nil {
+		return nil, err
+	}
+	registration := certmanager.WebhookRegistration{
+		Name:             webhookConfiguration,
+		ServiceNamespace: k8sclient.ProvisionerNamespace(),
+		ServiceName:      serviceName,
+		ServicePort:      443,
+	}
+	var source certmanager.CertificateSource
+	if certSecret != "" {
+		source, err = certmanager.NewSelfSignedSource(clientSet, k8sclient.ProvisionerNamespace(), certSecret, serviceName)
+	} else if cert == "" || key == "" {
+		return nil, errors.New("configuring TLS is mandatory, either --self-signed-cert-secret or --tls-cert-bundle and --tls-private-key-file has to be set")
+	} else {
+		source, err = certmanager.NewFileSource(cert, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	go source.Run(stopCh)
+	if webhookConfiguration != "" {
+		go registration.KeepRegistered(clientSet, source, stopCh)
+	}
+	return source, nil
 }
@@ -0,0 +1,140 @@
+package certmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+)
+
+const (
+	caValidity      = 10 * 365 * 24 * time.Hour
+	servingValidity = 365 * 24 * time.Hour
+	// renewBefore is how long before its expiry a certificate is replaced
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// CertificateSource provides the serving certificate of the webhook and the CA bundle the API server has to trust
+type CertificateSource interface {
+	// GetCertificate is meant for tls.Config, so updated certificates are used without restart
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+	CABundle() []byte
+	// Run keeps the certificates up to date until stopCh is closed
+	Run(stopCh <-chan struct{})
+}
+
+type keyPair struct {
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newPrivateKey() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func generateCA(commonName string) (keyPair, error) {
+	key, keyPEM, err := newPrivateKey()
+	if err != nil {
+		return keyPair{}, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return keyPair{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return keyPair{}, err
+	}
+	return keyPair{certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), keyPEM: keyPEM}, nil
+}
+
+// generateServingCert issues a certificate for the DNS names signed by ca
+func generateServingCert(ca keyPair, dnsNames []string) (keyPair, error) {
+	caCert, err := parseCertificate(ca.certPEM)
+	if err != nil {
+		return keyPair{}, err
+	}
+	caTLS, err := tls.X509KeyPair(ca.certPEM, ca.keyPEM)
+	if err != nil {
+		return keyPair{}, err
+	}
+	key, keyPEM, err := newPrivateKey()
+	if err != nil {
+		return keyPair{}, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return keyPair{}, err
+	}
+	now := time.Now()
+	notAfter := now.Add(servingValidity)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caTLS.PrivateKey)
+	if err != nil {
+		return keyPair{}, err
+	}
+	return keyPair{certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), keyPEM: keyPEM}, nil
+}
+
+// parseCertificate returns the first certificate of the PEM data
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// needsRenewal reports whether the certificate is unparsable or expires within renewBefore
+func needsRenewal(certPEM []byte) bool {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return true
+	}
+	return time.Now().Add(renewBefore).After(cert.NotAfter)
+}
+
+// isValid reports whether the certificate is parsable and not expired yet
+func isValid(certPEM []byte) bool {
+	cert, err := parseCertificate(certPEM)
+	return err == nil && time.Now().Before(cert.NotAfter)
+}
@@ -0,0 +1,109 @@
+package certmanager
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// fileReloadPeriod is how often the files are checked for modifications
+const fileReloadPeriod = 10 * time.Second
+
+// FileSource serves the certificate of a cert bundle and a key file, reloading them when they are modified
+type FileSource struct {
+	certFile string
+	keyFile  string
+
+	lock     sync.Mutex
+	modTime  time.Time
+	cert     *tls.Certificate
+	caBundle []byte
+}
+
+func NewFileSource(certFile string, keyFile string) (*FileSource, error) {
+	source := &FileSource{certFile: certFile, keyFile: keyFile}
+	if err := source.reload(); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (source *FileSource) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	return source.cert, nil
+}
+
+// CABundle returns the CA certificate concatenated after the serving certificate in the bundle,
+// or the serving certificate itself when the bundle holds only one
+func (source *FileSource) CABundle() []byte {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	return source.caBundle
+}
+
+// Run reloads the files periodically when they are modified, until stopCh is closed
+func (source *FileSource) Run(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := source.reload(); err != nil {
+			log.Println("WARNING: Cannot reload TLS certificate, keeping the current one, because: " + err.Error())
+		}
+	}, fileReloadPeriod, stopCh)
+}
+
+func (source *FileSource) reload() error {
+	modTime, err := latestModTime(source.certFile, source.keyFile)
+	if err != nil {
+		return err
+	}
+	source.lock.Lock()
+	if source.cert != nil && !modTime.After(source.modTime) {
+		source.lock.Unlock()
+		return nil
+	}
+	err = source.load(modTime)
+	source.lock.Unlock()
+	return err
+}
+
+// load reads the files, the lock of source has to be held
+func (source *FileSource) load(modTime time.Time) error {
+	certPEM, err := ioutil.ReadFile(source.certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(source.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return errors.New("invalid key pair " + source.certFile + ", " + source.keyFile + ": " + err.Error())
+	}
+	source.cert = &cert
+	source.caBundle = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[len(cert.Certificate)-1]})
+	source.modTime = modTime
+	log.Println("INFO: TLS certificate " + source.certFile + " loaded")
+	return nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,189 @@
+package certmanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	webhookNameSuffix = ".dynamic-local-pv-provisioner.nokia.k8s.io"
+	// registrationPeriod is how often the CA bundle of the source is compared to the registered one
+	registrationPeriod = 10 * time.Second
+)
+
+// WebhookRegistration describes the MutatingWebhookConfiguration and ValidatingWebhookConfiguration the webhook keeps up to date,
+// both are named Name
+type WebhookRegistration struct {
+	Name             string
+	ServiceNamespace string
+	ServiceName      string
+	ServicePort      int32
+}
+
+// KeepRegistered registers the CA bundle of source periodically whenever it differs from the registered one,
+// so failed registrations are retried and rotated certificates are trusted, until stopCh is closed
+func (registration WebhookRegistration) KeepRegistered(k8sClient kubernetes.Interface, source CertificateSource, stopCh <-chan struct{}) {
+	var registeredBundle []byte
+	wait.Until(func() {
+		caBundle := source.CABundle()
+		if registeredBundle != nil && bytes.Equal(caBundle, registeredBundle) {
+			return
+		}
+		if err := registration.Register(k8sClient, caBundle); err != nil {
+			log.Println("ERROR: Cannot register webhook configurations " + registration.Name + ", because: " + err.Error())
+			return
+		}
+		registeredBundle = caBundle
+		log.Println("INFO: Webhook configurations " + registration.Name + " registered with the current CA bundle")
+	}, registrationPeriod, stopCh)
+}
+
+// Register creates the MutatingWebhookConfiguration and the ValidatingWebhookConfiguration, or updates the caBundle
+// of their webhooks calling the service if they exist, keeping every other setting of the existing webhooks
+func (registration WebhookRegistration) Register(k8sClient kubernetes.Interface, caBundle []byte) error {
+	if err := registration.registerMutating(k8sClient, caBundle); err != nil {
+		return errors.New("MutatingWebhookConfiguration: " + err.Error())
+	}
+	if err := registration.registerValidating(k8sClient, caBundle); err != nil {
+		return errors.New("ValidatingWebhookConfiguration: " + err.Error())
+	}
+	return nil
+}
+
+func (registration WebhookRegistration) registerMutating(k8sClient kubernetes.Interface, caBundle []byte) error {
+	configurations := k8sClient.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configuration, err := configurations.Get(context.TODO(), registration.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			configuration = &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: registration.Name},
+				Webhooks:   registration.mutatingWebhooks(caBundle),
+			}
+			_, err = configurations.Create(context.TODO(), configuration, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		updated := false
+		for i := range configuration.Webhooks {
+			if registration.updateCABundle(&configuration.Webhooks[i].ClientConfig, caBundle) {
+				updated = true
+			}
+		}
+		if !updated {
+			return nil
+		}
+		_, err = configurations.Update(context.TODO(), configuration, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (registration WebhookRegistration) registerValidating(k8sClient kubernetes.Interface, caBundle []byte) error {
+	configurations := k8sClient.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configuration, err := configurations.Get(context.TODO(), registration.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			configuration = &admissionregistrationv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: registration.Name},
+				Webhooks:   registration.validatingWebhooks(caBundle),
+			}
+			_, err = configurations.Create(context.TODO(), configuration, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		updated := false
+		for i := range configuration.Webhooks {
+			if registration.updateCABundle(&configuration.Webhooks[i].ClientConfig, caBundle) {
+				updated = true
+			}
+		}
+		if !updated {
+			return nil
+		}
+		_, err = configurations.Update(context.TODO(), configuration, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// updateCABundle sets the caBundle of clientConfig if it calls the service, and reports whether it changed
+func (registration WebhookRegistration) updateCABundle(clientConfig *admissionregistrationv1.WebhookClientConfig, caBundle []byte) bool {
+	if !registration.isOwnService(clientConfig.Service) || bytes.Equal(clientConfig.CABundle, caBundle) {
+		return false
+	}
+	clientConfig.CABundle = caBundle
+	return true
+}
+
+func (registration WebhookRegistration) isOwnService(service *admissionregistrationv1.ServiceReference) bool {
+	return service != nil && service.Namespace == registration.ServiceNamespace && service.Name == registration.ServiceName
+}
+
+func (registration WebhookRegistration) mutatingWebhooks(caBundle []byte) []admissionregistrationv1.MutatingWebhook {
+	return []admissionregistrationv1.MutatingWebhook{
+		registration.mutatingWebhook("pvc", "/mutating-pvc", "persistentvolumeclaims", admissionregistrationv1.Fail, caBundle),
+		// pods without local volumes must not be blocked while the webhook is unavailable
+		registration.mutatingWebhook("pod", "/mutating-pod", "pods", admissionregistrationv1.Ignore, caBundle),
+	}
+}
+
+func (registration WebhookRegistration) mutatingWebhook(name string, path string, resource string, failurePolicy admissionregistrationv1.FailurePolicyType, caBundle []byte) admissionregistrationv1.MutatingWebhook {
+	sideEffects := admissionregistrationv1.SideEffectClassNoneOnDryRun
+	return admissionregistrationv1.MutatingWebhook{
+		Name:                    name + webhookNameSuffix,
+		ClientConfig:            registration.clientConfig(path, caBundle),
+		Rules:                   rules(resource, admissionregistrationv1.Create),
+		SideEffects:             &sideEffects,
+		FailurePolicy:           &failurePolicy,
+		AdmissionReviewVersions: []string{"v1", "v1beta1"},
+	}
+}
+
+func (registration WebhookRegistration) validatingWebhooks(caBundle []byte) []admissionregistrationv1.ValidatingWebhook {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	failurePolicy := admissionregistrationv1.Fail
+	return []admissionregistrationv1.ValidatingWebhook{{
+		Name:                    "validate-pvc" + webhookNameSuffix,
+		ClientConfig:            registration.clientConfig("/validating-pvc", caBundle),
+		Rules:                   rules("persistentvolumeclaims", admissionregistrationv1.Create, admissionregistrationv1.Update),
+		SideEffects:             &sideEffects,
+		FailurePolicy:           &failurePolicy,
+		AdmissionReviewVersions: []string{"v1", "v1beta1"},
+	}}
+}
+
+func (registration WebhookRegistration) clientConfig(path string, caBundle []byte) admissionregistrationv1.WebhookClientConfig {
+	port := registration.ServicePort
+	return admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Namespace: registration.ServiceNamespace,
+			Name:      registration.ServiceName,
+			Path:      &path,
+			Port:      &port,
+		},
+		CABundle: caBundle,
+	}
+}
+
+func rules(resource string, operations ...admissionregistrationv1.OperationType) []admissionregistrationv1.RuleWithOperations {
+	return []admissionregistrationv1.RuleWithOperations{{
+		Operations: operations,
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{""},
+			APIVersions: []string{"v1"},
+			Resources:   []string{resource},
+		},
+	}}
+}
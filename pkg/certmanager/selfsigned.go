@@ -0,0 +1,212 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	caCertKey         = "ca.crt"
+	caKeyKey          = "ca.key"
+	nextCACertKey     = "next-ca.crt"
+	nextCAKeyKey      = "next-ca.key"
+	previousCACertKey = "previous-ca.crt"
+	caRotationTimeKey = "ca-rotation-time"
+	// caOverlap is how long a rotated CA is published together with the other one before the rotation proceeds,
+	// long enough for every replica to sync the Secret and to register the CA bundle
+	caOverlap = 5*secretSyncPeriod + registrationPeriod
+	// secretSyncPeriod is how often the Secret is checked for expiry and for certificates rotated by other replicas
+	secretSyncPeriod = time.Minute
+)
+
+// SelfSignedSource keeps a self-signed CA and the serving certificate of the webhook service in a Secret,
+// shared by every replica of the webhook, and renews them before they expire
+type SelfSignedSource struct {
+	k8sClient   kubernetes.Interface
+	namespace   string
+	secretName  string
+	serviceName string
+
+	lock     sync.Mutex
+	cert     *tls.Certificate
+	caBundle []byte
+}
+
+func NewSelfSignedSource(k8sClient kubernetes.Interface, namespace string, secretName string, serviceName string) (*SelfSignedSource, error) {
+	source := &SelfSignedSource{
+		k8sClient:   k8sClient,
+		namespace:   namespace,
+		secretName:  secretName,
+		serviceName: serviceName,
+	}
+	if err := source.sync(); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (source *SelfSignedSource) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	return source.cert, nil
+}
+
+func (source *SelfSignedSource) CABundle() []byte {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	return source.caBundle
+}
+
+func (source *SelfSignedSource) Run(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := source.sync(); err != nil {
+			log.Println("ERROR: Cannot synchronize webhook certificates with Secret " + source.secretName + ", because: " + err.Error())
+		}
+	}, secretSyncPeriod, stopCh)
+}
+
+func (source *SelfSignedSource) dnsNames() []string {
+	return []string{
+		source.serviceName + "." + source.namespace + ".svc",
+		source.serviceName + "." + source.namespace + ".svc.cluster.local",
+		source.serviceName + "." + source.namespace,
+		source.serviceName,
+	}
+}
+
+// sync renews the certificates of the Secret if needed and loads them
+func (source *SelfSignedSource) sync() error {
+	secrets := source.k8sClient.CoreV1().Secrets(source.namespace)
+	secret, err := secrets.Get(context.TODO(), source.secretName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: source.secretName, Namespace: source.namespace},
+			Type:       v1.SecretTypeTLS,
+			Data:       map[string][]byte{},
+		}
+		if _, err = source.renew(secret); err != nil {
+			return err
+		}
+		secret, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+		if k8serrors.IsAlreadyExists(err) {
+			// another replica was faster, use its certificates
+			secret, err = secrets.Get(context.TODO(), source.secretName, metav1.GetOptions{})
+		}
+	} else if err == nil {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		var renewed bool
+		if renewed, err = source.renew(secret); err != nil {
+			return err
+		}
+		if renewed {
+			secret, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+			if k8serrors.IsConflict(err) {
+				secret, err = secrets.Get(context.TODO(), source.secretName, metav1.GetOptions{})
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return source.load(secret)
+}
+
+// renew advances the certificates in the data of secret and reports whether they changed.
+// An expiring CA is rotated in steps, so the API server never sees a serving certificate it does not trust yet:
+// the new CA is published next to the current one first, it signs the serving certificate only after caOverlap,
+// and the replaced CA stays published for another caOverlap, until every replica serves the reissued certificate.
+func (source *SelfSignedSource) renew(secret *v1.Secret) (bool, error) {
+	data := secret.Data
+	rotationDue := time.Since(rotationTime(data)) >= caOverlap
+	renewed := false
+	if len(data[previousCACertKey]) > 0 && rotationDue {
+		delete(data, previousCACertKey)
+		log.Println("INFO: Removed the replaced webhook CA from Secret " + source.secretName)
+		renewed = true
+	}
+	if len(data[nextCACertKey]) > 0 {
+		if !rotationDue {
+			return renewed, nil
+		}
+		data[previousCACertKey] = data[caCertKey]
+		data[caCertKey] = data[nextCACertKey]
+		data[caKeyKey] = data[nextCAKeyKey]
+		delete(data, nextCACertKey)
+		delete(data, nextCAKeyKey)
+		data[caRotationTimeKey] = []byte(time.Now().Format(time.RFC3339))
+		log.Println("INFO: Switched to the new webhook CA in Secret " + source.secretName)
+		return true, source.renewServingCert(data)
+	}
+	if needsRenewal(data[caCertKey]) {
+		ca, err := generateCA(source.serviceName + "-ca")
+		if err != nil {
+			return renewed, errors.New("cannot generate CA: " + err.Error())
+		}
+		if !isValid(data[caCertKey]) {
+			// nothing trusts the current CA anymore, there is nothing to overlap with
+			data[caCertKey] = ca.certPEM
+			data[caKeyKey] = ca.keyPEM
+			log.Println("INFO: Generated new webhook CA in Secret " + source.secretName)
+			return true, source.renewServingCert(data)
+		}
+		data[nextCACertKey] = ca.certPEM
+		data[nextCAKeyKey] = ca.keyPEM
+		data[caRotationTimeKey] = []byte(time.Now().Format(time.RFC3339))
+		log.Println("INFO: Generated new webhook CA in Secret " + source.secretName + ", it is published before it is used")
+		return true, nil
+	}
+	if needsRenewal(data[v1.TLSCertKey]) {
+		return true, source.renewServingCert(data)
+	}
+	return renewed, nil
+}
+
+func (source *SelfSignedSource) renewServingCert(data map[string][]byte) error {
+	ca := keyPair{certPEM: data[caCertKey], keyPEM: data[caKeyKey]}
+	serving, err := generateServingCert(ca, source.dnsNames())
+	if err != nil {
+		return errors.New("cannot generate serving certificate: " + err.Error())
+	}
+	log.Println("INFO: Generated new webhook serving certificate in Secret " + source.secretName)
+	data[v1.TLSCertKey] = serving.certPEM
+	data[v1.TLSPrivateKeyKey] = serving.keyPEM
+	return nil
+}
+
+// rotationTime returns when the last CA rotation step happened, or the zero time if it is unknown
+func rotationTime(data map[string][]byte) time.Time {
+	rotationTime, err := time.Parse(time.RFC3339, string(data[caRotationTimeKey]))
+	if err != nil {
+		return time.Time{}
+	}
+	return rotationTime
+}
+
+func (source *SelfSignedSource) load(secret *v1.Secret) error {
+	cert, err := tls.X509KeyPair(secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey])
+	if err != nil {
+		return errors.New("invalid key pair in Secret " + source.secretName + ": " + err.Error())
+	}
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	source.cert = &cert
+	// every published CA is trusted, so the serving certificate of any rotation step is accepted
+	var caBundle []byte
+	for _, key := range []string{caCertKey, nextCACertKey, previousCACertKey} {
+		caBundle = append(caBundle, secret.Data[key]...)
+	}
+	source.caBundle = caBundle
+	return nil
+}
@@ -0,0 +1,197 @@
+package extender
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// localClaims describes the local PVCs of a pod which are not bound yet
+type localClaims struct {
+	// node the already placed claims are pinned to, empty if none of them is placed
+	pinnedNode string
+	// storage requested by the claims which are still waiting for a node
+	request resource.Quantity
+	found   bool
+}
+
+type Extender struct{}
+
+func NewExtender() *Extender {
+	return &Extender{}
+}
+
+func (extender *Extender) ServeFilter(w http.ResponseWriter, r *http.Request) {
+	var (
+		args   extenderv1.ExtenderArgs
+		result extenderv1.ExtenderFilterResult
+	)
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		log.Println("ERROR: Decode filter request is failed, because " + err.Error())
+		result.Error = err.Error()
+		writeResponse(w, result)
+		return
+	}
+	result = filter(args)
+	writeResponse(w, result)
+}
+
+func (extender *Extender) ServePrioritize(w http.ResponseWriter, r *http.Request) {
+	var args extenderv1.ExtenderArgs
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		log.Println("ERROR: Decode prioritize request is failed, because " + err.Error())
+		writeResponse(w, extenderv1.HostPriorityList{})
+		return
+	}
+	writeResponse(w, prioritize(args))
+}
+
+func filter(args extenderv1.ExtenderArgs) extenderv1.ExtenderFilterResult {
+	result := extenderv1.ExtenderFilterResult{FailedNodes: extenderv1.FailedNodesMap{}}
+	nodes, err := getNodes(args)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	claims, err := getLocalClaims(args.Pod)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	var (
+		passedNodes []v1.Node
+		nodeNames   []string
+	)
+	for _, node := range nodes {
+		if claims.found {
+			if claims.pinnedNode != "" && claims.pinnedNode != node.ObjectMeta.Name {
+				result.FailedNodes[node.ObjectMeta.Name] = "Local volume is placed on node " + claims.pinnedNode
+				continue
+			}
+			if !claims.request.IsZero() {
+				nodeCapacity, ok := node.Status.Capacity[k8sclient.LvCapacity]
+				if !ok {
+					result.FailedNodes[node.ObjectMeta.Name] = "No local storage pool on node"
+					continue
+				}
+				if (&nodeCapacity).Cmp(claims.request) < 0 {
+					result.FailedNodes[node.ObjectMeta.Name] = "Not enough free space in local storage pool"
+					continue
+				}
+			}
+		}
+		passedNodes = append(passedNodes, node)
+		nodeNames = append(nodeNames, node.ObjectMeta.Name)
+	}
+	if args.Nodes != nil {
+		result.Nodes = &v1.NodeList{Items: passedNodes}
+	} else {
+		result.NodeNames = &nodeNames
+	}
+	return result
+}
+
+func prioritize(args extenderv1.ExtenderArgs) extenderv1.HostPriorityList {
+	var (
+		priorities  extenderv1.HostPriorityList
+		maxCapacity int64
+	)
+	nodes, err := getNodes(args)
+	if err != nil {
+		log.Println("ERROR: Cannot prioritize nodes, because " + err.Error())
+		return priorities
+	}
+	claims, err := getLocalClaims(args.Pod)
+	if err != nil {
+		log.Println("ERROR: Cannot prioritize nodes, because " + err.Error())
+	}
+	freeCapacity := make(map[string]int64)
+	if claims.found {
+		for _, node := range nodes {
+			nodeCapacity, ok := node.Status.Capacity[k8sclient.LvCapacity]
+			if !ok {
+				continue
+			}
+			(&nodeCapacity).Sub(claims.request)
+			freeCapacity[node.ObjectMeta.Name] = (&nodeCapacity).Value()
+			if freeCapacity[node.ObjectMeta.Name] > maxCapacity {
+				maxCapacity = freeCapacity[node.ObjectMeta.Name]
+			}
+		}
+	}
+	for _, node := range nodes {
+		var score int64
+		if free := freeCapacity[node.ObjectMeta.Name]; free > 0 && maxCapacity > 0 {
+			score = int64(float64(free) / float64(maxCapacity) * float64(extenderv1.MaxExtenderPriority))
+		}
+		priorities = append(priorities, extenderv1.HostPriority{Host: node.ObjectMeta.Name, Score: score})
+	}
+	return priorities
+}
+
+func getNodes(args extenderv1.ExtenderArgs) ([]v1.Node, error) {
+	if args.Nodes != nil {
+		return args.Nodes.Items, nil
+	}
+	if args.NodeNames == nil {
+		return nil, errors.New("Neither nodes nor node names are set in extender request!")
+	}
+	var nodes []v1.Node
+	for _, nodeName := range *args.NodeNames {
+		node, err := k8sclient.GetNode(nodeName)
+		if err != nil {
+			return nil, errors.New("Cannot get node " + nodeName + ", because: " + err.Error())
+		}
+		nodes = append(nodes, *node)
+	}
+	return nodes, nil
+}
+
+func getLocalClaims(pod *v1.Pod) (localClaims, error) {
+	var claims localClaims
+	if pod == nil {
+		return claims, nil
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := k8sclient.GetPvc(pod.ObjectMeta.Namespace, volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return claims, errors.New("Cannot get pvc " + volume.PersistentVolumeClaim.ClaimName + ", because: " + err.Error())
+		}
+		if pvc.Status.Phase == v1.ClaimBound {
+			continue
+		}
+		if isLocal, _ := k8sclient.PvcIsNokiaLocal(pvc); !isLocal {
+			continue
+		}
+		claims.found = true
+		if nodeName, ok := pvc.ObjectMeta.Annotations[k8sclient.NodeName]; ok {
+			if claims.pinnedNode != "" && claims.pinnedNode != nodeName {
+				return claims, errors.New("Local volumes of pod " + pod.ObjectMeta.Name + " are placed on different nodes!")
+			}
+			claims.pinnedNode = nodeName
+			continue
+		}
+		claims.request.Add(pvc.Spec.Resources.Requests[v1.ResourceStorage])
+	}
+	return claims, nil
+}
+
+func writeResponse(w http.ResponseWriter, response interface{}) {
+	respBytes, err := json.Marshal(response)
+	if err != nil {
+		log.Println("ERROR: Marshal extender response is failed, because " + err.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(respBytes); err != nil {
+		log.Println("ERROR: Write response is failed, because " + err.Error())
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const heartbeatPeriod = 10 * time.Second
+
+// Heartbeat renews the Lease telling the webhook that the executor of this node is able to provision volumes
+type Heartbeat struct {
+	nodeName  string
+	k8sClient kubernetes.Interface
+}
+
+func NewHeartbeat() (*Heartbeat, error) {
+	kubeClient, err := k8sclient.GetClientSet()
+	if err != nil {
+		return nil, err
+	}
+	return &Heartbeat{nodeName: os.Getenv("NODE_NAME"), k8sClient: kubeClient}, nil
+}
+
+func (heartbeat *Heartbeat) Run(stopCh <-chan struct{}) {
+	wait.Until(heartbeat.renew, heartbeatPeriod, stopCh)
+}
+
+func (heartbeat *Heartbeat) renew() {
+	leases := heartbeat.k8sClient.CoordinationV1().Leases(k8sclient.ProvisionerNamespace())
+	leaseName := k8sclient.HeartbeatLeasePrefix + heartbeat.nodeName
+	now := metav1.NewMicroTime(time.Now())
+	lease, err := leases.Get(context.TODO(), leaseName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		durationSeconds := int32(heartbeatPeriod.Seconds())
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: leaseName},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &heartbeat.nodeName,
+				LeaseDurationSeconds: &durationSeconds,
+				RenewTime:            &now,
+			},
+		}
+		_, err = leases.Create(context.TODO(), lease, metav1.CreateOptions{})
+		if err != nil {
+			log.Println("Heartbeat ERROR: Cannot create lease " + leaseName + ", because: " + err.Error())
+		}
+		return
+	}
+	if err != nil {
+		log.Println("Heartbeat ERROR: Cannot get lease " + leaseName + ", because: " + err.Error())
+		return
+	}
+	lease.Spec.HolderIdentity = &heartbeat.nodeName
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+	if err != nil {
+		log.Println("Heartbeat ERROR: Cannot renew lease " + leaseName + ", because: " + err.Error())
+	}
+}
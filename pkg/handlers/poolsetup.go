@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+const (
+	poolFsType       = "xfs"
+	poolFsLabel      = "dlpp-pool"
+	poolMountOptions = "prjquota"
+	mountsPath       = "/proc/mounts"
+	projectsPath     = "/etc/projects"
+	projidPath       = "/etc/projid"
+)
+
+// SetupStoragePool prepares storagePath from a raw block device: it creates an XFS filesystem with project quota
+// support on first start, mounts it, persists the mount in fstab and initialises the projects files.
+// Devices already containing a filesystem not created by the provisioner are only formatted when force is set.
+func SetupStoragePool(devicePattern string, storagePath string, force bool) error {
+	device, err := resolveDevice(devicePattern)
+	if err != nil {
+		return err
+	}
+	mountSource, mounted, err := getMountSource(storagePath)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		sameDevice, err := isSameDevice(mountSource, device)
+		if err != nil {
+			return err
+		}
+		if !sameDevice {
+			return errors.New(storagePath + " is already mounted from " + mountSource + " instead of " + device + ", refusing to use it as storage pool!")
+		}
+	} else {
+		signature, err := probeSignature(device)
+		if err != nil {
+			return err
+		}
+		if signature != "" && signature != poolSignature {
+			if !force {
+				return errors.New("Device " + device + " already contains " + signature + ", refusing to format it without --force-format!")
+			}
+			log.Println("WARNING: Formatting device " + device + " despite existing " + signature + ", because --force-format is set")
+		}
+		if signature != poolSignature {
+			log.Println("INFO: Creating " + poolFsType + " filesystem on " + device)
+			output, err := exec.Command("mkfs."+poolFsType, "-f", "-L", poolFsLabel, device).CombinedOutput()
+			if err != nil {
+				return errors.New("Cannot create filesystem on " + device + ", because: " + err.Error() + ": " + string(output))
+			}
+		}
+		err = os.MkdirAll(storagePath, os.ModePerm)
+		if err != nil {
+			return errors.New("Cannot create directory " + storagePath + ", because: " + err.Error())
+		}
+		err = syscall.Mount(device, storagePath, poolFsType, 0, poolMountOptions)
+		if err != nil {
+			return errors.New("Cannot mount " + device + " to " + storagePath + ", because: " + err.Error())
+		}
+	}
+	err = persistPoolMount(device, storagePath)
+	if err != nil {
+		return err
+	}
+	for _, filePath := range []string{projectsPath, projidPath} {
+		file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDONLY, 0644)
+		if err != nil {
+			return errors.New("Cannot initialise " + filePath + " file, because: " + err.Error())
+		}
+		file.Close()
+	}
+	return nil
+}
+
+func resolveDevice(devicePattern string) (string, error) {
+	devices, err := filepath.Glob(devicePattern)
+	if err != nil {
+		return "", errors.New("Invalid device pattern " + devicePattern + ": " + err.Error())
+	}
+	switch len(devices) {
+	case 0:
+		return "", errors.New("No device found for " + devicePattern + "!")
+	case 1:
+		device, err := filepath.EvalSymlinks(devices[0])
+		if err != nil {
+			return "", errors.New("Cannot resolve device " + devices[0] + ", because: " + err.Error())
+		}
+		return device, nil
+	default:
+		return "", errors.New("Device pattern " + devicePattern + " matches more than one device: " + strings.Join(devices, ", "))
+	}
+}
+
+// getMountSource returns the source of the mount on path, if path is a mount point
+func getMountSource(path string) (string, bool, error) {
+	file, err := os.Open(mountsPath)
+	if err != nil {
+		return "", false, errors.New("Cannot read " + mountsPath + ", because: " + err.Error())
+	}
+	defer file.Close()
+	cleanPath := filepath.Clean(path)
+	source, mounted := "", false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// the last mount on the path is the visible one
+		if len(fields) > 1 && fields[1] == cleanPath {
+			source, mounted = fields[0], true
+		}
+	}
+	return source, mounted, scanner.Err()
+}
+
+// isSameDevice reports whether the mount source is the device, compared by device number as the names may differ
+func isSameDevice(source string, device string) (bool, error) {
+	var sourceStat, deviceStat syscall.Stat_t
+	if err := syscall.Stat(source, &sourceStat); err != nil {
+		// e.g. tmpfs or overlay sources are not device files
+		return false, nil
+	}
+	if err := syscall.Stat(device, &deviceStat); err != nil {
+		return false, errors.New("Cannot stat device " + device + ", because: " + err.Error())
+	}
+	return sourceStat.Mode&syscall.S_IFMT == syscall.S_IFBLK && sourceStat.Rdev == deviceStat.Rdev, nil
+}
+
+// poolSignature is reported by probeSignature for a filesystem created by the provisioner
+const poolSignature = poolFsType + " filesystem " + poolFsLabel
+
+// probeSignature describes the signatures found on device, e.g. a filesystem or a partition table, or is empty for a blank device
+func probeSignature(device string) (string, error) {
+	output, err := exec.Command("blkid", "-p", "-o", "export", device).Output()
+	if err != nil {
+		// blkid exits with 2 when no signature could be found
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 2 {
+			return "", nil
+		}
+		return "", errors.New("Cannot probe device " + device + ", because: " + err.Error())
+	}
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		keyValue := strings.SplitN(line, "=", 2)
+		if len(keyValue) == 2 && keyValue[0] != "DEVNAME" {
+			values[keyValue[0]] = keyValue[1]
+		}
+	}
+	switch {
+	case len(values) == 0:
+		return "", nil
+	case values["PTTYPE"] != "":
+		return "a " + values["PTTYPE"] + " partition table", nil
+	case values["TYPE"] == poolFsType && values["LABEL"] == poolFsLabel:
+		return poolSignature, nil
+	case values["TYPE"] != "":
+		return "a " + values["TYPE"] + " signature", nil
+	default:
+		return "an unknown signature", nil
+	}
+}
+
+func persistPoolMount(device string, storagePath string) error {
+	uuid, err := exec.Command("blkid", "-s", "UUID", "-o", "value", device).Output()
+	if err != nil {
+		return errors.New("Cannot get UUID of " + device + ", because: " + err.Error())
+	}
+	fstabContent, err := ioutil.ReadFile(fstabPath)
+	if err != nil {
+		return errors.New("Cannot read fstab file: " + fstabPath + " because: " + err.Error())
+	}
+	cleanPath := filepath.Clean(storagePath)
+	for _, line := range strings.Split(string(fstabContent), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[1] == cleanPath {
+			return nil
+		}
+	}
+	file, err := os.OpenFile(fstabPath, os.O_APPEND|os.O_WRONLY|os.O_SYNC, 0755)
+	if err != nil {
+		return errors.New("Cannot open fstab file: " + fstabPath + " because: " + err.Error())
+	}
+	defer file.Close()
+	mountCommand := fmt.Sprintf("UUID=%s %s %s defaults,%s 0 0\n", strings.TrimSpace(string(uuid)), cleanPath, poolFsType, poolMountOptions)
+	_, err = file.WriteString(mountCommand)
+	if err != nil {
+		return errors.New("Cannot modify fstab file: " + fstabPath + " because: " + err.Error())
+	}
+	return nil
+}
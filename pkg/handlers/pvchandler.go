@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -11,21 +13,19 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
 
 	syscall "golang.org/x/sys/unix"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/informers"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 )
 
 const (
-	fstabPath           = "/rootfs/fstab"
-	pvDirNameAnnotation = "nokia.k8s.io/pvDirName"
+	fstabPath = "/rootfs/fstab"
 )
 
 type PvcHandler struct {
@@ -34,8 +34,8 @@ type PvcHandler struct {
 	k8sClient   kubernetes.Interface
 }
 
-func NewPvcHandler(storagePath string, cfg *rest.Config) (*PvcHandler, error) {
-	kubeClient, err := kubernetes.NewForConfig(cfg)
+func NewPvcHandler(storagePath string) (*PvcHandler, error) {
+	kubeClient, err := k8sclient.GetClientSet()
 	if err != nil {
 		return nil, err
 	}
@@ -47,8 +47,11 @@ func NewPvcHandler(storagePath string, cfg *rest.Config) (*PvcHandler, error) {
 	return &pvcHandler, err
 }
 
-func (pvcHandler *PvcHandler) CreateController() cache.Controller {
-	kubeInformerFactory := informers.NewSharedInformerFactory(pvcHandler.k8sClient, time.Second*30)
+func (pvcHandler *PvcHandler) CreateController() (cache.Controller, error) {
+	kubeInformerFactory, err := k8sclient.InformerFactory()
+	if err != nil {
+		return nil, err
+	}
 	controller := kubeInformerFactory.Core().V1().PersistentVolumeClaims().Informer()
 	controller.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
@@ -61,23 +64,64 @@ func (pvcHandler *PvcHandler) CreateController() cache.Controller {
 			pvcHandler.pvcChanged(*(reflect.ValueOf(oldObj).Interface().(*v1.PersistentVolumeClaim)), *(reflect.ValueOf(newObj).Interface().(*v1.PersistentVolumeClaim)))
 		},
 	})
-	return controller
+	return controller, nil
 }
 
 func (pvcHandler *PvcHandler) pvcAdded(pvc v1.PersistentVolumeClaim) {
+	if pvcHandler.claimSelectedNode(pvc) {
+		return
+	}
 	handlePvc, pvDirPath := shouldPvcBeHandled(v1.PersistentVolumeClaim{}, pvc, pvcHandler.nodeName, pvcHandler.storagePath)
-	if !handlePvc || !pvcHandler.enoughLvCapacity(pvc) {
+	if !handlePvc {
 		return
 	}
-	pvcHandler.createPVStorage(pvc, pvDirPath)
+	pvcHandler.provision(pvc, pvDirPath)
 }
 
 func (pvcHandler *PvcHandler) pvcChanged(oldPvc v1.PersistentVolumeClaim, newPvc v1.PersistentVolumeClaim) {
+	if pvcHandler.claimSelectedNode(newPvc) {
+		return
+	}
 	handlePvc, pvDirPath := shouldPvcBeHandled(oldPvc, newPvc, pvcHandler.nodeName, pvcHandler.storagePath)
-	if !handlePvc || !pvcHandler.enoughLvCapacity(newPvc) {
+	if !handlePvc {
 		return
 	}
-	pvcHandler.createPVStorage(newPvc, pvDirPath)
+	pvcHandler.provision(newPvc, pvDirPath)
+}
+
+// provision creates the storage of the pvc, or marks it with the ProvisioningFailed condition so it can be re-placed.
+// The pvc is pre-bound to its PV when it is placed, the volumeName is only assigned here for pvcs placed without it.
+func (pvcHandler *PvcHandler) provision(pvc v1.PersistentVolumeClaim, pvDirPath string) {
+	if !pvcHandler.enoughLvCapacity(pvc) {
+		if k8sclient.GetProvisioningFailure(&pvc) != nil {
+			return
+		}
+		storageRequest := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+		err := k8sclient.SetProvisioningFailed(&pvc, v1.ConditionTrue, "InsufficientCapacity", "Not enough free lv-capacity on node "+pvcHandler.nodeName+" for "+storageRequest.String())
+		if err != nil {
+			log.Println("PvcHandler ERROR: Cannot set failure condition of pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+		}
+		return
+	}
+	if pvc.Spec.VolumeName == "" {
+		storageClassName, err := k8sclient.GetPvcStorageClassName(&pvc)
+		if err != nil {
+			log.Println("PvcHandler ERROR: Cannot resolve storageclass of pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+			return
+		}
+		volumeName := k8sclient.GeneratePVName(pvc.ObjectMeta.Annotations[k8sclient.PvDirName], pvcHandler.nodeName, storageClassName)
+		patchBytes, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"volumeName": volumeName}})
+		if err != nil {
+			log.Println("PvcHandler ERROR: Cannot marshal patch for pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+			return
+		}
+		_, err = pvcHandler.k8sClient.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Patch(context.TODO(), pvc.ObjectMeta.Name, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+		if err != nil {
+			log.Println("PvcHandler ERROR: Cannot set volumeName of pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+			return
+		}
+	}
+	pvcHandler.createPVStorage(pvc, pvDirPath)
 }
 
 func (pvcHandler *PvcHandler) pvcDeleted(pvc v1.PersistentVolumeClaim) {
@@ -91,6 +135,59 @@ func (pvcHandler *PvcHandler) pvcDeleted(pvc v1.PersistentVolumeClaim) {
 	}
 }
 
+// claimSelectedNode finishes the placement of WaitForFirstConsumer PVCs the scheduler selected this node for.
+// The provisioning itself starts on the update event caused by the nodeName annotation.
+func (pvcHandler *PvcHandler) claimSelectedNode(pvc v1.PersistentVolumeClaim) bool {
+	if pvc.ObjectMeta.Annotations[k8sclient.SelectedNode] != pvcHandler.nodeName || pvc.Status.Phase != v1.ClaimPending {
+		return false
+	}
+	if _, ok := pvc.ObjectMeta.Annotations[k8sclient.NodeName]; ok {
+		return false
+	}
+	storageClass, err := k8sclient.GetPvcStorageClass(&pvc)
+	if err != nil || storageClass == nil || storageClass.Provisioner != k8sclient.LocalScProvisioner || !k8sclient.IsWaitForFirstConsumer(storageClass) {
+		return false
+	}
+	var patchData map[string]interface{}
+	if pvcHandler.enoughLvCapacity(pvc) {
+		// the pvDirName cannot be changed once set, it is only generated for pvcs without one
+		pvDirName, ok := pvc.ObjectMeta.Annotations[k8sclient.PvDirName]
+		if !ok {
+			pvDirName = k8sclient.GeneratePvDirName(pvc)
+		}
+		patchData = map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{
+					k8sclient.NodeName:  pvcHandler.nodeName,
+					k8sclient.PvDirName: pvDirName,
+				},
+			},
+		}
+		if pvc.Spec.VolumeName == "" {
+			patchData["spec"] = map[string]interface{}{
+				"volumeName": k8sclient.GeneratePVName(pvDirName, pvcHandler.nodeName, storageClass.ObjectMeta.Name),
+			}
+		}
+	} else {
+		// give the node back to the scheduler so it can pick another one
+		patchData = map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{k8sclient.SelectedNode: nil},
+			},
+		}
+	}
+	patchBytes, err := json.Marshal(patchData)
+	if err != nil {
+		log.Println("PvcHandler ERROR: Cannot marshal patch for pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+		return true
+	}
+	_, err = pvcHandler.k8sClient.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Patch(context.TODO(), pvc.ObjectMeta.Name, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+	if err != nil {
+		log.Println("PvcHandler ERROR: Cannot patch pvc " + pvc.ObjectMeta.Name + " for selected node, because: " + err.Error())
+	}
+	return true
+}
+
 func (pvcHandler *PvcHandler) enoughLvCapacity(pvc v1.PersistentVolumeClaim) bool {
 	node, err := k8sclient.GetNode(pvcHandler.nodeName)
 	if err != nil {
@@ -106,11 +203,11 @@ func (pvcHandler *PvcHandler) enoughLvCapacity(pvc v1.PersistentVolumeClaim) boo
 }
 
 func shouldPvcBeHandled(oldPvc v1.PersistentVolumeClaim, newPvc v1.PersistentVolumeClaim, nodeName string, storagePath string) (bool, string) {
-	pvcIsLocal, _ := k8sclient.StorageClassIsNokiaLocal(*(newPvc.Spec.StorageClassName))
+	pvcIsLocal, _ := k8sclient.PvcIsNokiaLocal(&newPvc)
 	if pvcIsLocal && isChangeEnoughToProceed(oldPvc, newPvc) {
 		if pvcNodeName, ok := newPvc.ObjectMeta.Annotations[k8sclient.NodeName]; ok && pvcNodeName == nodeName {
 			if newPvc.Status.Phase == v1.ClaimPending {
-				if pvDirName, ok := newPvc.ObjectMeta.Annotations[pvDirNameAnnotation]; ok {
+				if pvDirName, ok := newPvc.ObjectMeta.Annotations[k8sclient.PvDirName]; ok {
 					pvDir := storagePath + pvDirName
 					if _, err := os.Stat(pvDir); os.IsNotExist(err) {
 						return true, pvDir
@@ -124,7 +221,7 @@ func shouldPvcBeHandled(oldPvc v1.PersistentVolumeClaim, newPvc v1.PersistentVol
 
 func shouldDeletePvcBeHandled(pvc v1.PersistentVolumeClaim, nodeName string) bool {
 	pvcNodeName, ok := pvc.ObjectMeta.Annotations[k8sclient.NodeName]
-	pvcIsLocal, _ := k8sclient.StorageClassIsNokiaLocal(*(pvc.Spec.StorageClassName))
+	pvcIsLocal, _ := k8sclient.PvcIsNokiaLocal(&pvc)
 	if pvcIsLocal && ok && pvcNodeName == nodeName && pvc.Status.Phase == v1.ClaimBound && pvc.Spec.VolumeName != "" {
 		return true
 	}
@@ -299,7 +396,8 @@ func isChangeEnoughToProceed(oldPvc v1.PersistentVolumeClaim, newPvc v1.Persiste
 	old_nodename := oldPvc.ObjectMeta.Annotations[k8sclient.NodeName]
 	new_nodename := newPvc.ObjectMeta.Annotations[k8sclient.NodeName]
 	if !reflect.DeepEqual(oldPvc, v1.PersistentVolumeClaim{}) {
-		if old_nodename != new_nodename && old_nodename == "" {
+		// the node is either assigned for the first time, or re-assigned after the provisioning failed on the previous one
+		if old_nodename != new_nodename && new_nodename != "" {
 			return true
 		}
 	} else { // in case the created PVC already has the "nokia.k8s.io/nodeName" annotation otherwise set by provisioner
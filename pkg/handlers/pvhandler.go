@@ -6,15 +6,12 @@ import (
 	"os"
 	"reflect"
 	"strings"
-	"time"
 
 	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
 	syscall "golang.org/x/sys/unix"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
-	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -24,8 +21,8 @@ type PvHandler struct {
 	k8sClient   kubernetes.Interface
 }
 
-func NewPvHandler(storagePath string, cfg *rest.Config) (*PvHandler, error) {
-	kubeClient, err := kubernetes.NewForConfig(cfg)
+func NewPvHandler(storagePath string) (*PvHandler, error) {
+	kubeClient, err := k8sclient.GetClientSet()
 	if err != nil {
 		return nil, err
 	}
@@ -35,23 +32,26 @@ func NewPvHandler(storagePath string, cfg *rest.Config) (*PvHandler, error) {
 		storagePath: storagePath,
 		k8sClient:   kubeClient,
 	}
-	lvCap, err := lvmAvailableCapacity(storagePath)
+	lvCap, lvSize, err := lvmAvailableCapacity(storagePath)
 	if err != nil {
 		return nil, err
 	}
-	err = createLVCapacityResource(nodeName, lvCap, kubeClient)
+	err = createLVCapacityResource(nodeName, lvCap, lvSize)
 	return &pvHandler, err
 }
 
-func (pvHandler *PvHandler) CreateController() cache.Controller {
-	kubeInformerFactory := informers.NewSharedInformerFactory(pvHandler.k8sClient, time.Second*30)
+func (pvHandler *PvHandler) CreateController() (cache.Controller, error) {
+	kubeInformerFactory, err := k8sclient.InformerFactory()
+	if err != nil {
+		return nil, err
+	}
 	controller := kubeInformerFactory.Core().V1().PersistentVolumes().Informer()
 	controller.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    func(obj interface{}) { pvHandler.pvAdded(*(reflect.ValueOf(obj).Interface().(*v1.PersistentVolume))) },
 		DeleteFunc: func(obj interface{}) { pvHandler.pvDeleted(*(reflect.ValueOf(obj).Interface().(*v1.PersistentVolume))) },
 		UpdateFunc: func(oldObj, newObj interface{}) {},
 	})
-	return controller
+	return controller, nil
 }
 
 func (pvHandler *PvHandler) pvAdded(pv v1.PersistentVolume) {
@@ -99,14 +99,11 @@ func (pvHandler *PvHandler) handlePv(pv v1.PersistentVolume) bool {
 
 func (pvHandler *PvHandler) increaseStorageCap(pv v1.PersistentVolume) error {
 	pvCapacity := pv.Spec.Capacity["storage"]
-	node, err := k8sclient.GetNode(pvHandler.nodeName)
-	if err != nil {
-		return errors.New("Cannot get node(" + pvHandler.nodeName + "), because: " + err.Error())
-	}
-	nodeCap := node.Status.Capacity[k8sclient.LvCapacity]
-	(&nodeCap).Add(pvCapacity)
-	node.Status.Capacity[k8sclient.LvCapacity] = nodeCap
-	err = k8sclient.UpdateNodeStatus(pvHandler.nodeName, node)
+	err := k8sclient.UpdateNodeCapacity(pvHandler.nodeName, func(capacity v1.ResourceList) {
+		nodeCap := capacity[k8sclient.LvCapacity]
+		(&nodeCap).Add(pvCapacity)
+		capacity[k8sclient.LvCapacity] = nodeCap
+	})
 	if err != nil {
 		return errors.New("Cannot update node(" + pvHandler.nodeName + "), because: " + err.Error())
 	}
@@ -115,39 +112,33 @@ func (pvHandler *PvHandler) increaseStorageCap(pv v1.PersistentVolume) error {
 
 func (pvHandler *PvHandler) decreaseStorageCap(pv v1.PersistentVolume) error {
 	pvCapacity := pv.Spec.Capacity["storage"]
-	node, err := k8sclient.GetNode(pvHandler.nodeName)
-	if err != nil {
-		return errors.New("Cannot get node(" + pvHandler.nodeName + "), because: " + err.Error())
-	}
-	nodeCap := node.Status.Capacity[k8sclient.LvCapacity]
-	(&nodeCap).Sub(pvCapacity)
-	node.Status.Capacity[k8sclient.LvCapacity] = nodeCap
-	err = k8sclient.UpdateNodeStatus(pvHandler.nodeName, node)
+	err := k8sclient.UpdateNodeCapacity(pvHandler.nodeName, func(capacity v1.ResourceList) {
+		nodeCap := capacity[k8sclient.LvCapacity]
+		(&nodeCap).Sub(pvCapacity)
+		capacity[k8sclient.LvCapacity] = nodeCap
+	})
 	if err != nil {
 		return errors.New("Cannot update node(" + pvHandler.nodeName + "), because: " + err.Error())
 	}
 	return nil
 }
 
-func createLVCapacityResource(nodeName string, lvCapacity int64, kubeClient kubernetes.Interface) error {
-	node, err := k8sclient.GetNode(nodeName)
-	if err != nil {
-		return errors.New("Cannot get node(" + nodeName + "), because: " + err.Error())
-	}
-	lvCapQuantity := resource.NewQuantity(lvCapacity, resource.BinarySI)
-	node.Status.Capacity[k8sclient.LvCapacity] = *lvCapQuantity
-	err = k8sclient.UpdateNodeStatus(nodeName, node)
+func createLVCapacityResource(nodeName string, lvCapacity int64, lvSize int64) error {
+	err := k8sclient.UpdateNodeCapacity(nodeName, func(capacity v1.ResourceList) {
+		capacity[k8sclient.LvCapacity] = *resource.NewQuantity(lvCapacity, resource.BinarySI)
+		capacity[k8sclient.LvSize] = *resource.NewQuantity(lvSize, resource.BinarySI)
+	})
 	if err != nil {
 		return errors.New("Cannot update node(" + nodeName + "), because: " + err.Error())
 	}
 	return nil
 }
 
-func lvmAvailableCapacity(lvPath string) (int64, error) {
+func lvmAvailableCapacity(lvPath string) (int64, int64, error) {
 	fs := syscall.Statfs_t{}
 	err := syscall.Statfs(lvPath, &fs)
 	if err != nil {
-		return 0, errors.New("Cannot get FS info from: " + lvPath + " because: " + err.Error())
+		return 0, 0, errors.New("Cannot get FS info from: " + lvPath + " because: " + err.Error())
 	}
-	return int64(fs.Bavail) * fs.Bsize, nil
+	return int64(fs.Bavail) * fs.Bsize, int64(fs.Blocks) * fs.Bsize, nil
 }
@@ -0,0 +1,65 @@
+package k8sclient
+
+import (
+	"context"
+	"encoding/json"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// ProvisioningFailed is the condition the executor sets on pvcs it cannot provision on their assigned node
+	ProvisioningFailed v1.PersistentVolumeClaimConditionType = "LocalProvisioningFailed"
+)
+
+// SetProvisioningFailed sets the ProvisioningFailed condition of the pvc to status, keeping its other conditions
+func SetProvisioningFailed(pvc *v1.PersistentVolumeClaim, status v1.ConditionStatus, reason string, message string) error {
+	clientSet, err := getClientSet()
+	if err != nil {
+		return err
+	}
+	patchData := map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []v1.PersistentVolumeClaimCondition{{
+				Type:               ProvisioningFailed,
+				Status:             status,
+				Reason:             reason,
+				Message:            message,
+				LastTransitionTime: metav1.Now(),
+			}},
+		},
+	}
+	patchBytes, err := json.Marshal(patchData)
+	if err != nil {
+		return err
+	}
+	_, err = clientSet.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Patch(context.TODO(), pvc.ObjectMeta.Name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{}, "status")
+	return err
+}
+
+// GetProvisioningFailure returns the ProvisioningFailed condition of the pvc if it is true
+func GetProvisioningFailure(pvc *v1.PersistentVolumeClaim) *v1.PersistentVolumeClaimCondition {
+	for i := range pvc.Status.Conditions {
+		if pvc.Status.Conditions[i].Type == ProvisioningFailed && pvc.Status.Conditions[i].Status == v1.ConditionTrue {
+			return &pvc.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// GetFailedPvcs returns the unbound pvcs whose provisioning failed on their assigned node, pre-bound or not
+func GetFailedPvcs() ([]v1.PersistentVolumeClaim, error) {
+	pvcs, err := listPvcs()
+	if err != nil {
+		return nil, err
+	}
+	var failed []v1.PersistentVolumeClaim
+	for _, pvc := range pvcs {
+		if pvc.Status.Phase == v1.ClaimPending && pvc.ObjectMeta.DeletionTimestamp == nil && GetProvisioningFailure(pvc) != nil {
+			failed = append(failed, *pvc.DeepCopy())
+		}
+	}
+	return failed, nil
+}
@@ -0,0 +1,166 @@
+package k8sclient
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ExecutorHeartbeatTimeout is the age after which the executor of a node is considered dead, zero disables the check
+var ExecutorHeartbeatTimeout = 40 * time.Second
+
+// filterEligibleNodes drops nodes which are NotReady, cordoned, have untolerated NoSchedule or NoExecute taints
+// or whose executor stopped renewing its heartbeat, the reason of each drop is recorded in decision
+func filterEligibleNodes(nodes []v1.Node, tolerations []v1.Toleration, decision *PlacementDecision) ([]v1.Node, error) {
+	var (
+		eligibleNodes []v1.Node
+		heartbeats    map[string]time.Time
+		err           error
+	)
+	if ExecutorHeartbeatTimeout > 0 {
+		heartbeats, err = getExecutorHeartbeats()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, node := range nodes {
+		reason := ""
+		switch {
+		case !isNodeReady(node):
+			reason = "node is NotReady"
+		case node.Spec.Unschedulable:
+			reason = "node is cordoned"
+		case !toleratesTaints(node.Spec.Taints, tolerations):
+			reason = "node has untolerated taints"
+		case ExecutorHeartbeatTimeout > 0 && time.Since(heartbeats[node.ObjectMeta.Name]) > ExecutorHeartbeatTimeout:
+			reason = "executor heartbeat is missing"
+		}
+		if reason != "" {
+			decision.excludeNode(node.ObjectMeta.Name, reason)
+			continue
+		}
+		eligibleNodes = append(eligibleNodes, node)
+	}
+	return eligibleNodes, nil
+}
+
+// filterAllowedNodePools drops nodes matching none of the pool selectors, an empty pool list allows every node
+func filterAllowedNodePools(nodes []v1.Node, pools []string) ([]v1.Node, error) {
+	if len(pools) == 0 {
+		return nodes, nil
+	}
+	var poolSelectors []labels.Selector
+	for _, pool := range pools {
+		poolSelector, err := labels.Parse(pool)
+		if err != nil {
+			return nil, err
+		}
+		poolSelectors = append(poolSelectors, poolSelector)
+	}
+	var allowedNodes []v1.Node
+	for _, node := range nodes {
+		for _, poolSelector := range poolSelectors {
+			if poolSelector.Matches(labels.Set(node.ObjectMeta.Labels)) {
+				allowedNodes = append(allowedNodes, node)
+				break
+			}
+		}
+	}
+	return allowedNodes, nil
+}
+
+func isNodeReady(node v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func toleratesTaints(taints []v1.Taint, tolerations []v1.Toleration) bool {
+	for i := range taints {
+		if taints[i].Effect != v1.TaintEffectNoSchedule && taints[i].Effect != v1.TaintEffectNoExecute {
+			continue
+		}
+		tolerated := false
+		for j := range tolerations {
+			if tolerations[j].ToleratesTaint(&taints[i]) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
+
+var leaseLister coordinationlisters.LeaseNamespaceLister
+
+// WatchExecutorHeartbeats caches the executor Leases, so the eligibility of nodes is checked without API calls
+func WatchExecutorHeartbeats(stopCh <-chan struct{}) error {
+	clientSet, err := getClientSet()
+	if err != nil {
+		return err
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(clientSet, informerResyncPeriod, informers.WithNamespace(ProvisionerNamespace()))
+	leaseInformer := factory.Coordination().V1().Leases()
+	lister := leaseInformer.Lister().Leases(ProvisionerNamespace())
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, leaseInformer.Informer().HasSynced) {
+		return errors.New("Lease informer could not sync!")
+	}
+	listersLock.Lock()
+	leaseLister = lister
+	listersLock.Unlock()
+	return nil
+}
+
+// getExecutorHeartbeats returns the last renewal time of the executor Leases per node, the holder of each Lease is its node
+func getExecutorHeartbeats() (map[string]time.Time, error) {
+	heartbeats := make(map[string]time.Time)
+	leases, err := listLeases()
+	if err != nil {
+		return nil, err
+	}
+	for _, lease := range leases {
+		if !strings.HasPrefix(lease.ObjectMeta.Name, HeartbeatLeasePrefix) || lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil {
+			continue
+		}
+		heartbeats[*lease.Spec.HolderIdentity] = lease.Spec.RenewTime.Time
+	}
+	return heartbeats, nil
+}
+
+func listLeases() ([]*coordinationv1.Lease, error) {
+	listersLock.RLock()
+	lister := leaseLister
+	listersLock.RUnlock()
+	if lister != nil {
+		return lister.List(labels.Everything())
+	}
+	clientSet, err := getClientSet()
+	if err != nil {
+		return nil, err
+	}
+	leaseList, err := clientSet.CoordinationV1().Leases(ProvisionerNamespace()).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var leases []*coordinationv1.Lease
+	for i := range leaseList.Items {
+		leases = append(leases, &leaseList.Items[i])
+	}
+	return leases, nil
+}
@@ -0,0 +1,44 @@
+package k8sclient
+
+import (
+	"log"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const eventComponent = "dynamic-local-pv-provisioner"
+
+var (
+	recorderLock  sync.Mutex
+	eventRecorder record.EventRecorder
+)
+
+func getEventRecorder() (record.EventRecorder, error) {
+	recorderLock.Lock()
+	defer recorderLock.Unlock()
+	if eventRecorder != nil {
+		return eventRecorder, nil
+	}
+	clientSet, err := getClientSet()
+	if err != nil {
+		return nil, err
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientSet.CoreV1().Events("")})
+	eventRecorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+	return eventRecorder, nil
+}
+
+// RecordPvcEvent attaches an event to the pvc, failures are only logged as events are informative
+func RecordPvcEvent(pvc *v1.PersistentVolumeClaim, eventType string, reason string, message string) {
+	recorder, err := getEventRecorder()
+	if err != nil {
+		log.Println("WARNING: Cannot record event " + reason + " for pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+		return
+	}
+	recorder.Event(pvc, eventType, reason, message)
+}
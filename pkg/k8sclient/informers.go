@@ -0,0 +1,158 @@
+package k8sclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/rest"
+)
+
+const informerResyncPeriod = time.Second * 30
+
+// listers serve the queries of the package from the informer caches once they are synced
+type listers struct {
+	nodes          corelisters.NodeLister
+	storageClasses storagelisters.StorageClassLister
+	pvcs           corelisters.PersistentVolumeClaimLister
+	pvs            corelisters.PersistentVolumeLister
+}
+
+var (
+	clientLock      sync.Mutex
+	clientConfig    *rest.Config
+	clientSet       kubernetes.Interface
+	dynamicClient   dynamic.Interface
+	informerFactory informers.SharedInformerFactory
+	cachedListers   *listers
+	listersLock     sync.RWMutex
+)
+
+// SetConfig overrides the in-cluster config the shared client is built from, it has to be called before any query
+func SetConfig(cfg *rest.Config) {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+	clientConfig = cfg
+}
+
+// GetClientSet returns the client shared by every component of the process
+func GetClientSet() (kubernetes.Interface, error) {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+	if clientSet != nil {
+		return clientSet, nil
+	}
+	config, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+	newClientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.New("Error creating clientset: " + err.Error())
+	}
+	clientSet = newClientSet
+	return clientSet, nil
+}
+
+func getClientSet() (kubernetes.Interface, error) {
+	return GetClientSet()
+}
+
+// getDynamicClient returns the client of the custom resources, built from the same config as the shared client
+func getDynamicClient() (dynamic.Interface, error) {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+	if dynamicClient != nil {
+		return dynamicClient, nil
+	}
+	config, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+	newDynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, errors.New("Error creating dynamic client: " + err.Error())
+	}
+	dynamicClient = newDynamicClient
+	return dynamicClient, nil
+}
+
+// restConfig returns the config set by SetConfig or the in-cluster one, clientLock has to be held
+func restConfig() (*rest.Config, error) {
+	if clientConfig != nil {
+		return clientConfig, nil
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, errors.New("Error creating InCluster config: " + err.Error())
+	}
+	return config, nil
+}
+
+// InformerFactory returns the informer factory shared by every component of the process
+func InformerFactory() (informers.SharedInformerFactory, error) {
+	kubeClient, err := GetClientSet()
+	if err != nil {
+		return nil, err
+	}
+	clientLock.Lock()
+	defer clientLock.Unlock()
+	if informerFactory == nil {
+		informerFactory = informers.NewSharedInformerFactory(kubeClient, informerResyncPeriod)
+	}
+	return informerFactory, nil
+}
+
+// StartInformers starts every informer requested from the shared factory, including the Node, StorageClass, PVC and PV ones.
+// Queries are answered from the caches instead of the API server once they are synced.
+func StartInformers(stopCh <-chan struct{}) error {
+	factory, err := InformerFactory()
+	if err != nil {
+		return err
+	}
+	newListers := &listers{
+		nodes:          factory.Core().V1().Nodes().Lister(),
+		storageClasses: factory.Storage().V1().StorageClasses().Lister(),
+		pvcs:           factory.Core().V1().PersistentVolumeClaims().Lister(),
+		pvs:            factory.Core().V1().PersistentVolumes().Lister(),
+	}
+	factory.Start(stopCh)
+	for informerType, synced := range factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return errors.New("Informer for " + informerType.String() + " could not sync!")
+		}
+	}
+	listersLock.Lock()
+	cachedListers = newListers
+	listersLock.Unlock()
+	return nil
+}
+
+func getListers() *listers {
+	listersLock.RLock()
+	defer listersLock.RUnlock()
+	return cachedListers
+}
+
+func listCachedNodes(cache *listers, label string) (v1.NodeList, error) {
+	selector, err := labels.Parse(label)
+	if err != nil {
+		return v1.NodeList{}, err
+	}
+	nodes, err := cache.nodes.List(selector)
+	if err != nil {
+		return v1.NodeList{}, err
+	}
+	nodeList := v1.NodeList{}
+	for _, node := range nodes {
+		nodeList.Items = append(nodeList.Items, *node.DeepCopy())
+	}
+	return nodeList, nil
+}
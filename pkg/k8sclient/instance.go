@@ -0,0 +1,70 @@
+package k8sclient
+
+import (
+	"errors"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	DefaultProvisionerName  = "nokia.k8s.io/local"
+	DefaultAnnotationDomain = "nokia.k8s.io"
+)
+
+// The names below depend on the provisioner instance, several instances with different names and domains
+// can run side by side, each handling only the StorageClasses of its own provisioner name
+var (
+	LocalScProvisioner = DefaultProvisionerName
+	// LvCapacity is the free space of the storage pool of the node, published as a node resource
+	LvCapacity v1.ResourceName = DefaultAnnotationDomain + "/lv-capacity"
+	// LvSize is the total size of the storage pool of the node
+	LvSize    v1.ResourceName = DefaultAnnotationDomain + "/lv-size"
+	NodeName                  = DefaultAnnotationDomain + "/nodeName"
+	PvDirName                 = DefaultAnnotationDomain + "/pvDirName"
+	// NodeSelectorAnnotation restricts the nodes the volume of the pvc can be placed on
+	NodeSelectorAnnotation = DefaultAnnotationDomain + "/nodeSelector"
+	// PlacementExplanation holds the JSON PlacementDecision of the node chosen for the pvc
+	PlacementExplanation = DefaultAnnotationDomain + "/placementExplanation"
+	// ReservationKey identifies the in-flight reservation of a pvc admitted before its name was generated
+	ReservationKey = DefaultAnnotationDomain + "/reservationKey"
+	// HeartbeatLeasePrefix prefixes the name of the Lease each executor renews for its node
+	HeartbeatLeasePrefix = "dlpp-executor-"
+)
+
+// Configure sets the provisioner name and the domain of the annotations and node resources of this instance,
+// it has to be called before any other function of the package
+func Configure(provisionerName string, annotationDomain string) error {
+	if problems := validation.IsQualifiedName(provisionerName); len(problems) > 0 {
+		return errors.New("invalid provisioner name " + provisionerName + ": " + strings.Join(problems, ", "))
+	}
+	if problems := validation.IsDNS1123Subdomain(annotationDomain); len(problems) > 0 {
+		return errors.New("invalid annotation domain " + annotationDomain + ": " + strings.Join(problems, ", "))
+	}
+	LocalScProvisioner = provisionerName
+	LvCapacity = v1.ResourceName(annotationDomain + "/lv-capacity")
+	LvSize = v1.ResourceName(annotationDomain + "/lv-size")
+	NodeName = annotationDomain + "/nodeName"
+	PvDirName = annotationDomain + "/pvDirName"
+	NodeSelectorAnnotation = annotationDomain + "/nodeSelector"
+	PlacementExplanation = annotationDomain + "/placementExplanation"
+	ReservationKey = annotationDomain + "/reservationKey"
+	HeartbeatLeasePrefix = InstanceObjectName("dlpp-executor") + "-"
+	return nil
+}
+
+// InstanceObjectName returns the name of an object shared by the components of this instance, e.g. a Lease,
+// so instances running in the same namespace do not share them. The default instance keeps the plain names.
+func InstanceObjectName(name string) string {
+	if LocalScProvisioner == DefaultProvisionerName {
+		return name
+	}
+	suffix := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(LocalScProvisioner))
+	return name + "-" + strings.Trim(suffix, "-")
+}
@@ -2,113 +2,228 @@ package k8sclient
 
 import (
 	"context"
-	"errors"
 
-	"github.com/sbabiv/roundrobin"
 	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
 )
 
 const (
-	LvCapacity         = "nokia.k8s.io/lv-capacity"
-	LocalScProvisioner = "nokia.k8s.io/local"
-	NodeName           = "nokia.k8s.io/nodeName"
-	RR                 = "round robin"
-	Cap                = "capacity"
+	SelectedNode = "volume.kubernetes.io/selected-node"
+	// DefaultStorageClassAnnotation marks the storageclass of the pvcs not requesting any
+	DefaultStorageClassAnnotation     = "storageclass.kubernetes.io/is-default-class"
+	betaDefaultStorageClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+	RR                                = "round robin"
+	Cap                               = "capacity"
 )
 
-func getClientSet() (kubernetes.Interface, error) {
-	config, err := rest.InClusterConfig()
+func GetAllNodes() (v1.NodeList, error) {
+	return GetNodesByLabel("")
+}
+
+func GetNodesByLabel(label string) (v1.NodeList, error) {
+	if cache := getListers(); cache != nil {
+		return listCachedNodes(cache, label)
+	}
+	clientSet, err := getClientSet()
 	if err != nil {
-		return nil, errors.New("Error creating InCluster config: " + err.Error())
+		return v1.NodeList{}, err
 	}
-	clientset, err := kubernetes.NewForConfig(config)
+	nodeList, err := clientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: label})
 	if err != nil {
-		return nil, errors.New("Error creating clientset: " + err.Error())
+		return v1.NodeList{}, err
 	}
-	return clientset, nil
+	return *nodeList, nil
 }
 
-func GetAllNodes() (v1.NodeList, error) {
-	clientSet, err := getClientSet()
-	if err != nil {
-		return v1.NodeList{}, err
+// filterNodesByCapacity returns copies of the nodes fitting request, with their lv-capacity reduced by the reservations
+func filterNodesByCapacity(nodes []v1.Node, reservations map[string]resource.Quantity, request resource.Quantity) []v1.Node {
+	var candidates []v1.Node
+	for _, node := range nodes {
+		nodeCapacity, ok := node.Status.Capacity[LvCapacity]
+		if !ok {
+			continue
+		}
+		nodeCapacity = nodeCapacity.DeepCopy()
+		if reserved, ok := reservations[node.ObjectMeta.Name]; ok {
+			(&nodeCapacity).Sub(reserved)
+		}
+		if (&nodeCapacity).Cmp(request) < 0 {
+			continue
+		}
+		candidate := *node.DeepCopy()
+		candidate.Status.Capacity[LvCapacity] = nodeCapacity
+		candidates = append(candidates, candidate)
 	}
-	nodes, err := clientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
-	return *nodes, err
+	return candidates
 }
 
-func GetNodeByLabel(label string, selectorMethod string, rr *roundrobin.Balancer) (v1.Node, error) {
-	var (
-		returnNode  v1.Node
-		maxCapacity int64 = 0
-		listOption  metav1.ListOptions
-	)
-	clientSet, err := getClientSet()
+// GetPendingReservations sums the storage requests of local PVCs per assigned node, which have no PV yet
+func GetPendingReservations() (map[string]resource.Quantity, error) {
+	reservations := make(map[string]resource.Quantity)
+	pvcs, err := listPvcs()
 	if err != nil {
-		return v1.Node{}, err
+		return nil, err
 	}
-	listOption = metav1.ListOptions{LabelSelector: label}
-	nodeList, err := clientSet.CoreV1().Nodes().List(context.TODO(), listOption)
+	pvs, err := listPvs()
 	if err != nil {
-		return v1.Node{}, err
+		return nil, err
 	}
-	switch nodesLen := len(nodeList.Items); nodesLen {
-	case 0:
-		return v1.Node{}, errors.New("No nodes found for label:" + label + "!")
-	case 1:
-		return nodeList.Items[0], nil
-	default:
-		if selectorMethod == RR {
-			nodeId, _ := rr.Pick()
-			returnNode = nodeList.Items[nodeId.(int)%len(nodeList.Items)]
-		} else if selectorMethod == Cap {
-			for _, node := range nodeList.Items {
-				nodeCapacity, ok := node.Status.Capacity[LvCapacity]
-				if !ok {
-					continue
-				}
-				if (&nodeCapacity).CmpInt64(maxCapacity) == 1 {
-					maxCapacity = (&nodeCapacity).Value()
-					returnNode = node
-				}
-			}
-		}
+	existingPvs := make(map[string]bool)
+	for _, pv := range pvs {
+		existingPvs[pv.ObjectMeta.Name] = true
 	}
-	if returnNode.ObjectMeta.Name == "" {
-		return v1.Node{}, errors.New("No lv-capacity set, yet!")
+	localStorageClasses := make(map[string]bool)
+	for _, pvc := range pvcs {
+		nodeName, ok := pvc.ObjectMeta.Annotations[NodeName]
+		if !ok || pvc.Status.Phase != v1.ClaimPending || existingPvs[pvc.Spec.VolumeName] {
+			continue
+		}
+		scName, err := GetPvcStorageClassName(pvc)
+		if err != nil || scName == "" {
+			continue
+		}
+		isLocal, checked := localStorageClasses[scName]
+		if !checked {
+			isLocal, _ = StorageClassIsNokiaLocal(scName)
+			localStorageClasses[scName] = isLocal
+		}
+		if !isLocal {
+			continue
+		}
+		reserved := reservations[nodeName]
+		(&reserved).Add(pvc.Spec.Resources.Requests[v1.ResourceStorage])
+		reservations[nodeName] = reserved
 	}
-	return returnNode, nil
+	return reservations, nil
 }
 
-func UpdateNodeStatus(nodeName string, node *v1.Node) error {
+// UpdateNodeCapacity applies update on the capacity of the freshest version of the node, retrying on conflicts
+func UpdateNodeCapacity(nodeName string, update func(capacity v1.ResourceList)) error {
 	clientSet, err := getClientSet()
 	if err != nil {
 		return err
 	}
-	_, err = clientSet.CoreV1().Nodes().UpdateStatus(context.TODO(), node, metav1.UpdateOptions{})
-	if err != nil {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := clientSet.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if node.Status.Capacity == nil {
+			node.Status.Capacity = v1.ResourceList{}
+		}
+		update(node.Status.Capacity)
+		_, err = clientSet.CoreV1().Nodes().UpdateStatus(context.TODO(), node, metav1.UpdateOptions{})
 		return err
+	})
+}
+
+// GetPvcStorageClassName returns the storageclass of the pvc, the default storageclass if it does not set any.
+// An empty name means the pvc has no storageclass, e.g. it requested "" explicitly or there is no default.
+func GetPvcStorageClassName(pvc *v1.PersistentVolumeClaim) (string, error) {
+	if pvc.Spec.StorageClassName != nil {
+		return *pvc.Spec.StorageClassName, nil
 	}
-	return nil
+	defaultClass, err := GetDefaultStorageClass()
+	if err != nil || defaultClass == nil {
+		return "", err
+	}
+	return defaultClass.ObjectMeta.Name, nil
 }
 
-func StorageClassIsNokiaLocal(storageClassName string) (bool, error) {
-	clientSet, err := getClientSet()
-	if err != nil {
+// GetPvcStorageClass returns the storageclass of the pvc, resolving the default one, nil if the pvc has none
+func GetPvcStorageClass(pvc *v1.PersistentVolumeClaim) (*storagev1.StorageClass, error) {
+	storageClassName, err := GetPvcStorageClassName(pvc)
+	if err != nil || storageClassName == "" {
+		return nil, err
+	}
+	return GetStorageClass(storageClassName)
+}
+
+func PvcIsNokiaLocal(pvc *v1.PersistentVolumeClaim) (bool, error) {
+	storageClassName, err := GetPvcStorageClassName(pvc)
+	if err != nil || storageClassName == "" {
 		return false, err
 	}
+	return StorageClassIsNokiaLocal(storageClassName)
+}
+
+// GetDefaultStorageClass returns the storageclass annotated as default, the newest one if there are several
+// like the DefaultStorageClass admission plugin does, nil if there is none
+func GetDefaultStorageClass() (*storagev1.StorageClass, error) {
+	var storageClasses []*storagev1.StorageClass
+	if cache := getListers(); cache != nil {
+		cachedClasses, err := cache.storageClasses.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		storageClasses = cachedClasses
+	} else {
+		clientSet, err := getClientSet()
+		if err != nil {
+			return nil, err
+		}
+		storageClassList, err := clientSet.StorageV1().StorageClasses().List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range storageClassList.Items {
+			storageClasses = append(storageClasses, &storageClassList.Items[i])
+		}
+	}
+	var defaultClass *storagev1.StorageClass
+	for _, storageClass := range storageClasses {
+		if storageClass.ObjectMeta.Annotations[DefaultStorageClassAnnotation] != "true" && storageClass.ObjectMeta.Annotations[betaDefaultStorageClassAnnotation] != "true" {
+			continue
+		}
+		if defaultClass == nil || storageClass.ObjectMeta.CreationTimestamp.After(defaultClass.ObjectMeta.CreationTimestamp.Time) {
+			defaultClass = storageClass
+		}
+	}
+	if defaultClass == nil {
+		return nil, nil
+	}
+	return defaultClass.DeepCopy(), nil
+}
 
-	storageClass, err := clientSet.StorageV1().StorageClasses().Get(context.TODO(), storageClassName, metav1.GetOptions{})
+func StorageClassIsNokiaLocal(storageClassName string) (bool, error) {
+	storageClass, err := GetStorageClass(storageClassName)
 	if err != nil {
 		return false, err
 	}
 	return storageClass.Provisioner == LocalScProvisioner, nil
 }
 
+func GetStorageClass(storageClassName string) (*storagev1.StorageClass, error) {
+	if cache := getListers(); cache != nil {
+		storageClass, err := cache.storageClasses.Get(storageClassName)
+		if err != nil {
+			return nil, err
+		}
+		return storageClass.DeepCopy(), nil
+	}
+	clientSet, err := getClientSet()
+	if err != nil {
+		return nil, err
+	}
+	return clientSet.StorageV1().StorageClasses().Get(context.TODO(), storageClassName, metav1.GetOptions{})
+}
+
+func IsWaitForFirstConsumer(storageClass *storagev1.StorageClass) bool {
+	return storageClass.VolumeBindingMode != nil && *storageClass.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer
+}
+
 func GetNode(nodeName string) (*v1.Node, error) {
+	if cache := getListers(); cache != nil {
+		node, err := cache.nodes.Get(nodeName)
+		if err != nil {
+			return nil, err
+		}
+		return node.DeepCopy(), nil
+	}
 	clientSet, err := getClientSet()
 	if err != nil {
 		return nil, err
@@ -121,9 +236,98 @@ func GetNode(nodeName string) (*v1.Node, error) {
 }
 
 func GetVolume(pvName string) (*v1.PersistentVolume, error) {
+	if cache := getListers(); cache != nil {
+		pv, err := cache.pvs.Get(pvName)
+		if err != nil {
+			return nil, err
+		}
+		return pv.DeepCopy(), nil
+	}
 	clientSet, err := getClientSet()
 	if err != nil {
 		return nil, err
 	}
 	return clientSet.CoreV1().PersistentVolumes().Get(context.TODO(), pvName, metav1.GetOptions{})
 }
+
+func GetPvc(namespace string, pvcName string) (*v1.PersistentVolumeClaim, error) {
+	if cache := getListers(); cache != nil {
+		pvc, err := cache.pvcs.PersistentVolumeClaims(namespace).Get(pvcName)
+		if err != nil {
+			return nil, err
+		}
+		return pvc.DeepCopy(), nil
+	}
+	clientSet, err := getClientSet()
+	if err != nil {
+		return nil, err
+	}
+	return clientSet.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
+}
+
+// GetPvcFromServer reads the PVC from the API server, for callers which cannot wait for the cache to catch up
+func GetPvcFromServer(namespace string, pvcName string) (*v1.PersistentVolumeClaim, error) {
+	clientSet, err := getClientSet()
+	if err != nil {
+		return nil, err
+	}
+	return clientSet.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
+}
+
+// listPvcs returns the PVCs of all namespaces, the cached objects must not be modified
+func listPvcs() ([]*v1.PersistentVolumeClaim, error) {
+	if cache := getListers(); cache != nil {
+		return cache.pvcs.List(labels.Everything())
+	}
+	clientSet, err := getClientSet()
+	if err != nil {
+		return nil, err
+	}
+	pvcList, err := clientSet.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var pvcs []*v1.PersistentVolumeClaim
+	for i := range pvcList.Items {
+		pvcs = append(pvcs, &pvcList.Items[i])
+	}
+	return pvcs, nil
+}
+
+// listPvs returns all PVs, the cached objects must not be modified
+func listPvs() ([]*v1.PersistentVolume, error) {
+	if cache := getListers(); cache != nil {
+		return cache.pvs.List(labels.Everything())
+	}
+	clientSet, err := getClientSet()
+	if err != nil {
+		return nil, err
+	}
+	pvList, err := clientSet.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var pvs []*v1.PersistentVolume
+	for i := range pvList.Items {
+		pvs = append(pvs, &pvList.Items[i])
+	}
+	return pvs, nil
+}
+
+// GetUnplacedPvcs returns the pending pvcs with a local storageclass, which have neither a node nor a volume assigned
+func GetUnplacedPvcs() ([]v1.PersistentVolumeClaim, error) {
+	pvcs, err := listPvcs()
+	if err != nil {
+		return nil, err
+	}
+	var unplaced []v1.PersistentVolumeClaim
+	for _, pvc := range pvcs {
+		if _, ok := pvc.ObjectMeta.Annotations[NodeName]; ok || pvc.Status.Phase != v1.ClaimPending || pvc.Spec.VolumeName != "" {
+			continue
+		}
+		if isLocal, _ := PvcIsNokiaLocal(pvc); isLocal {
+			unplaced = append(unplaced, *pvc.DeepCopy())
+		}
+	}
+	return unplaced, nil
+}
@@ -0,0 +1,68 @@
+package k8sclient
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// leaderElections tracks the election loops, so the Leases can be released before the process exits
+var leaderElections sync.WaitGroup
+
+// RunLeaderElected runs run in only one of the replicas at a time, coordinated through the Lease lockName.
+// run has to return when its stop channel is closed, which happens when the leadership is lost or stopCh is closed.
+func RunLeaderElected(lockName string, stopCh <-chan struct{}, run func(stopCh <-chan struct{})) error {
+	clientSet, err := getClientSet()
+	if err != nil {
+		return err
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: InstanceObjectName(lockName), Namespace: ProvisionerNamespace()},
+		Client:     clientSet.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	leaderElections.Add(1)
+	go func() {
+		defer leaderElections.Done()
+		// a replica losing the leadership campaigns again, until the process stops
+		for ctx.Err() == nil {
+			leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+				Lock:            lock,
+				ReleaseOnCancel: true,
+				LeaseDuration:   15 * time.Second,
+				RenewDeadline:   10 * time.Second,
+				RetryPeriod:     2 * time.Second,
+				Callbacks: leaderelection.LeaderCallbacks{
+					OnStartedLeading: func(leaderCtx context.Context) {
+						log.Println("INFO: Started leading " + lockName)
+						run(leaderCtx.Done())
+					},
+					OnStoppedLeading: func() {
+						log.Println("INFO: Stopped leading " + lockName)
+					},
+				},
+			})
+		}
+	}()
+	return nil
+}
+
+// WaitForLeaderElections returns once every election loop stopped after the stop channel was closed, releasing its Lease
+func WaitForLeaderElections() {
+	leaderElections.Wait()
+}
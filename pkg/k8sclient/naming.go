@@ -0,0 +1,52 @@
+package k8sclient
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// random is seeded once, so the strategies and the names drawing from it in the same second still differ
+var (
+	randomLock sync.Mutex
+	random     = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func randomIntn(n int) int {
+	randomLock.Lock()
+	defer randomLock.Unlock()
+	return random.Intn(n)
+}
+
+func randomInt63n(n int64) int64 {
+	randomLock.Lock()
+	defer randomLock.Unlock()
+	return random.Int63n(n)
+}
+
+func GeneratePvDirName(pvc v1.PersistentVolumeClaim) string {
+	return pvc.ObjectMeta.Namespace + "_" + pvc.ObjectMeta.Name + "-" + generateRandomSuffix(8)
+}
+
+// GeneratePVName returns the name the local static provisioner gives to the PV discovered in pvDirName
+func GeneratePVName(file, node, class string) string {
+	h := fnv.New32a()
+	h.Write([]byte(file))
+	h.Write([]byte(node))
+	h.Write([]byte(class))
+	// This is the FNV-1a 32-bit hash
+	return fmt.Sprintf("local-pv-%x", h.Sum32())
+}
+
+func generateRandomSuffix(suffixlength int) string {
+	charPool := []byte("abcdefghijklmnopqrstuvwxyz1234567890")
+	bytes := make([]byte, suffixlength)
+	for i := range bytes {
+		bytes[i] = charPool[randomIntn(len(charPool))]
+	}
+	return string(bytes)
+}
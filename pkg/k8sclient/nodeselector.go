@@ -0,0 +1,226 @@
+package k8sclient
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	LeastAllocated   = "least-allocated"
+	MostAllocated    = "most-allocated"
+	Random           = "random"
+	WeightedCapacity = "weighted-capacity"
+	// NodeSelectorMethodParameter is the StorageClass parameter overriding the default node selector method
+	NodeSelectorMethodParameter = "nodeSelectorMethod"
+	// TolerationsParameter is the StorageClass parameter holding the JSON list of taints tolerated by its volumes
+	TolerationsParameter = "tolerations"
+)
+
+// SelectionRequest describes the placement the node is selected for
+type SelectionRequest struct {
+	// Selector is the label selector the candidate nodes are listed with
+	Selector string
+	// Method is the name of the NodeSelector strategy to use
+	Method string
+	// Size is the storage request of the volume
+	Size resource.Quantity
+	// Tolerations allow placing the volume on nodes with NoSchedule or NoExecute taints
+	Tolerations []v1.Toleration
+	// TopologyKeys are the node labels whose domains the volumes of Group are spread over, in order of precedence
+	TopologyKeys []string
+	// Group selects the PVCs the volume is spread with, nil disables spreading
+	Group *SpreadGroup
+	// AllowedNodePools are node selectors, the volume is only placed on nodes matching one of them if any is given
+	AllowedNodePools []string
+	// RequiredNodePools are lists of node selectors, the volume is only placed on nodes matching one selector of every list
+	RequiredNodePools [][]string
+	// ReservedCapacity is kept free in the pool of every node
+	ReservedCapacity resource.Quantity
+	// StatefulSetClaim is set when the volume is created for a volumeClaimTemplate of a StatefulSet
+	StatefulSetClaim *StatefulSetClaim
+	// ExcludedNodes are never selected, e.g. the node the provisioning of the volume failed on
+	ExcludedNodes []string
+	// Claim is the namespace/name key of the claim, its capacity is reserved under it until the claim shows up in the cache
+	Claim string
+	// DryRun requests an explanation only, the shared placement state is not changed
+	DryRun bool
+
+	// roundRobinCursors is the last picked node per label selector in the shared placement state
+	roundRobinCursors map[string]string
+}
+
+// NodeSelector is a strategy choosing the node of a new local volume from the nodes matching its label selector
+type NodeSelector interface {
+	// SelectNode is called with at least two candidate nodes, an empty node is returned when none of them is usable
+	SelectNode(request SelectionRequest, nodes []v1.Node) (v1.Node, error)
+}
+
+// NodeScorer is implemented by strategies which pick the candidate with the highest score,
+// the scores are reported in the placement decision
+type NodeScorer interface {
+	ScoreNodes(nodes []v1.Node) map[string]float64
+}
+
+// scoringSelector picks the node with the highest score, nodes without a score are never picked
+type scoringSelector func(node v1.Node) (float64, bool)
+
+func (score scoringSelector) SelectNode(request SelectionRequest, nodes []v1.Node) (v1.Node, error) {
+	var (
+		returnNode v1.Node
+		maxScore   float64
+	)
+	for _, node := range nodes {
+		nodeScore, ok := score(node)
+		if ok && (returnNode.ObjectMeta.Name == "" || nodeScore > maxScore) {
+			maxScore = nodeScore
+			returnNode = node
+		}
+	}
+	return returnNode, nil
+}
+
+func (score scoringSelector) ScoreNodes(nodes []v1.Node) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, node := range nodes {
+		if nodeScore, ok := score(node); ok {
+			scores[node.ObjectMeta.Name] = nodeScore
+		}
+	}
+	return scores
+}
+
+// NodeSelectorFunc adapts a stateless function to the NodeSelector interface
+type NodeSelectorFunc func(nodes []v1.Node) (v1.Node, error)
+
+func (f NodeSelectorFunc) SelectNode(request SelectionRequest, nodes []v1.Node) (v1.Node, error) {
+	return f(nodes)
+}
+
+var (
+	nodeSelectorsLock sync.RWMutex
+	nodeSelectors     = map[string]NodeSelector{}
+)
+
+func init() {
+	RegisterNodeSelector(RR, roundRobinSelector{})
+	RegisterNodeSelector(Cap, scoringSelector(scoreCapacity))
+	RegisterNodeSelector(LeastAllocated, scoringSelector(scoreLeastAllocated))
+	RegisterNodeSelector(MostAllocated, scoringSelector(scoreMostAllocated))
+	RegisterNodeSelector(Random, NodeSelectorFunc(selectRandom))
+	RegisterNodeSelector(WeightedCapacity, NodeSelectorFunc(selectWeightedByCapacity))
+}
+
+// RegisterNodeSelector makes a strategy available under name, registering an existing name replaces the strategy
+func RegisterNodeSelector(name string, selector NodeSelector) {
+	nodeSelectorsLock.Lock()
+	defer nodeSelectorsLock.Unlock()
+	nodeSelectors[name] = selector
+}
+
+func GetNodeSelector(name string) (NodeSelector, error) {
+	nodeSelectorsLock.RLock()
+	defer nodeSelectorsLock.RUnlock()
+	selector, ok := nodeSelectors[name]
+	if !ok {
+		return nil, errors.New("Unknown node selector method \"" + name + "\", acceptable values: \"" + strings.Join(nodeSelectorNames(), "\", \"") + "\"")
+	}
+	return selector, nil
+}
+
+func nodeSelectorNames() []string {
+	var names []string
+	for name := range nodeSelectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// roundRobinSelector hands out the currently matching nodes in name order, separately for each label selector.
+// The last node picked for a selector is remembered in the shared placement state instead of an index,
+// so nodes joining or leaving do not skew the rotation and every webhook replica continues the same rotation.
+type roundRobinSelector struct{}
+
+func (selector roundRobinSelector) SelectNode(request SelectionRequest, nodes []v1.Node) (v1.Node, error) {
+	sortedNodes := make([]v1.Node, len(nodes))
+	copy(sortedNodes, nodes)
+	sort.Slice(sortedNodes, func(i, j int) bool { return sortedNodes[i].ObjectMeta.Name < sortedNodes[j].ObjectMeta.Name })
+	returnNode := sortedNodes[0]
+	lastNode := request.roundRobinCursors[request.Selector]
+	for _, node := range sortedNodes {
+		if node.ObjectMeta.Name > lastNode {
+			returnNode = node
+			break
+		}
+	}
+	if request.roundRobinCursors != nil {
+		request.roundRobinCursors[request.Selector] = returnNode.ObjectMeta.Name
+	}
+	return returnNode, nil
+}
+
+// scoreCapacity prefers the node with the most free space
+func scoreCapacity(node v1.Node) (float64, bool) {
+	nodeCapacity, ok := node.Status.Capacity[LvCapacity]
+	if !ok || nodeCapacity.Sign() <= 0 {
+		return 0, false
+	}
+	return float64(nodeCapacity.Value()), true
+}
+
+// freeRatio returns the free fraction of the node's pool, false when the node did not publish its pool size
+func freeRatio(node v1.Node) (float64, bool) {
+	nodeCapacity, capOk := node.Status.Capacity[LvCapacity]
+	nodeSize, sizeOk := node.Status.Capacity[LvSize]
+	if !capOk || !sizeOk || nodeSize.IsZero() {
+		return 0, false
+	}
+	return float64(nodeCapacity.Value()) / float64(nodeSize.Value()), true
+}
+
+// scoreLeastAllocated prefers the node with the largest free fraction of its pool
+func scoreLeastAllocated(node v1.Node) (float64, bool) {
+	return freeRatio(node)
+}
+
+// scoreMostAllocated prefers the node with the smallest free fraction of its pool which still has free space, packing volumes together
+func scoreMostAllocated(node v1.Node) (float64, bool) {
+	nodeCapacity := node.Status.Capacity[LvCapacity]
+	if nodeCapacity.IsZero() {
+		return 0, false
+	}
+	ratio, ok := freeRatio(node)
+	return 1 - ratio, ok
+}
+
+func selectRandom(nodes []v1.Node) (v1.Node, error) {
+	return nodes[randomIntn(len(nodes))], nil
+}
+
+func selectWeightedByCapacity(nodes []v1.Node) (v1.Node, error) {
+	var totalCapacity int64
+	for _, node := range nodes {
+		nodeCapacity := node.Status.Capacity[LvCapacity]
+		totalCapacity += nodeCapacity.Value()
+	}
+	if totalCapacity <= 0 {
+		return v1.Node{}, nil
+	}
+	pick := randomInt63n(totalCapacity)
+	for _, node := range nodes {
+		nodeCapacity := node.Status.Capacity[LvCapacity]
+		if nodeCapacity.Value() <= 0 {
+			continue
+		}
+		if pick < nodeCapacity.Value() {
+			return node, nil
+		}
+		pick -= nodeCapacity.Value()
+	}
+	return v1.Node{}, nil
+}
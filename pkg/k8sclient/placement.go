@@ -0,0 +1,221 @@
+package k8sclient
+
+import (
+	"errors"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// PlacementDecision explains how the node of a volume was chosen
+type PlacementDecision struct {
+	// Selector is the effective label selector of the candidate nodes
+	Selector   string               `json:"selector"`
+	Strategy   string               `json:"strategy"`
+	Candidates []PlacementCandidate `json:"candidates"`
+	Node       string               `json:"node,omitempty"`
+	// Reason tells why Node won, or why no node could be chosen
+	Reason string `json:"reason"`
+}
+
+type PlacementCandidate struct {
+	Name string `json:"name"`
+	// FreeCapacity is the lv-capacity of the node reduced by the pending and reserved capacity
+	FreeCapacity string   `json:"freeCapacity,omitempty"`
+	Score        *float64 `json:"score,omitempty"`
+	// Excluded tells why the node was dropped before the strategy was applied
+	Excluded string `json:"excluded,omitempty"`
+}
+
+// exclude records reason for the nodes of before missing from after
+func (decision *PlacementDecision) exclude(before []v1.Node, after []v1.Node, reason string) {
+	kept := make(map[string]bool)
+	for _, node := range after {
+		kept[node.ObjectMeta.Name] = true
+	}
+	for _, node := range before {
+		if !kept[node.ObjectMeta.Name] {
+			decision.excludeNode(node.ObjectMeta.Name, reason)
+		}
+	}
+}
+
+func (decision *PlacementDecision) excludeNode(nodeName string, reason string) {
+	for i := range decision.Candidates {
+		if decision.Candidates[i].Name == nodeName && decision.Candidates[i].Excluded == "" {
+			decision.Candidates[i].Excluded = reason
+		}
+	}
+}
+
+func (decision *PlacementDecision) fail(err error) (v1.Node, PlacementDecision, error) {
+	decision.Reason = err.Error()
+	return v1.Node{}, *decision, err
+}
+
+// GetNodeByLabel selects a node for a new local volume among the eligible nodes matching the selector of request.
+// Nodes whose free lv-capacity, reduced by the volumes already assigned to them but not provisioned yet, cannot fit the request are skipped.
+// The returned decision explains the choice, it is filled in even when no node could be selected.
+func GetNodeByLabel(request SelectionRequest) (v1.Node, PlacementDecision, error) {
+	label := request.Selector
+	decision := &PlacementDecision{Selector: label, Strategy: request.Method}
+	selector, err := GetNodeSelector(request.Method)
+	if err != nil {
+		return decision.fail(err)
+	}
+	nodeList, err := GetNodesByLabel(label)
+	if err != nil {
+		return decision.fail(err)
+	}
+	if len(nodeList.Items) == 0 {
+		return decision.fail(errors.New("No nodes found for label:" + label + "!"))
+	}
+	for _, node := range nodeList.Items {
+		decision.Candidates = append(decision.Candidates, PlacementCandidate{Name: node.ObjectMeta.Name})
+	}
+	allowedNodes, err := filterPlaceableNodes(request, nodeList.Items, decision)
+	if err != nil {
+		return decision.fail(err)
+	}
+	if len(allowedNodes) == 0 {
+		return decision.fail(errors.New("No eligible nodes found for label:" + label + ", all of them are NotReady, cordoned, tainted, outside the allowed node pools, excluded or have no running executor!"))
+	}
+	// the capacity check and the selection are repeated if another webhook replica changed the shared state meanwhile
+	candidatesBefore := append([]PlacementCandidate(nil), decision.Candidates...)
+	var (
+		returnNode v1.Node
+		reason     string
+	)
+	err = updatePlacementState(request.DryRun, func(state *placementState) error {
+		decision.Candidates = append([]PlacementCandidate(nil), candidatesBefore...)
+		request.roundRobinCursors = state.RoundRobin
+		returnNode, reason, err = selectCandidate(request, selector, allowedNodes, state.Reservations, decision)
+		if err != nil {
+			return err
+		}
+		reserveInFlight(state.Reservations, request.Claim, returnNode.ObjectMeta.Name, request.Size)
+		return nil
+	})
+	if err != nil {
+		return decision.fail(err)
+	}
+	decision.Node = returnNode.ObjectMeta.Name
+	decision.Reason = reason
+	return returnNode, *decision, nil
+}
+
+// EligibleNodes returns the nodes the volume of request may be placed on, before its capacity is judged
+func EligibleNodes(request SelectionRequest, nodes []v1.Node) ([]v1.Node, error) {
+	return filterPlaceableNodes(request, nodes, &PlacementDecision{})
+}
+
+// filterPlaceableNodes applies the eligibility, node pool and exclusion constraints of request on nodes
+func filterPlaceableNodes(request SelectionRequest, nodes []v1.Node, decision *PlacementDecision) ([]v1.Node, error) {
+	eligibleNodes, err := filterEligibleNodes(nodes, request.Tolerations, decision)
+	if err != nil {
+		return nil, err
+	}
+	allowedNodes, err := filterAllowedNodePools(eligibleNodes, request.AllowedNodePools)
+	if err != nil {
+		return nil, err
+	}
+	decision.exclude(eligibleNodes, allowedNodes, "outside the allowed node pools")
+	for _, pools := range request.RequiredNodePools {
+		requiredNodes, err := filterAllowedNodePools(allowedNodes, pools)
+		if err != nil {
+			return nil, err
+		}
+		decision.exclude(allowedNodes, requiredNodes, "outside the node pools of a LocalStoragePolicy")
+		allowedNodes = requiredNodes
+	}
+	return filterExcludedNodes(allowedNodes, request.ExcludedNodes, decision), nil
+}
+
+func filterExcludedNodes(nodes []v1.Node, excludedNodes []string, decision *PlacementDecision) []v1.Node {
+	if len(excludedNodes) == 0 {
+		return nodes
+	}
+	excluded := make(map[string]bool)
+	for _, nodeName := range excludedNodes {
+		excluded[nodeName] = true
+	}
+	var remaining []v1.Node
+	for _, node := range nodes {
+		if excluded[node.ObjectMeta.Name] {
+			decision.excludeNode(node.ObjectMeta.Name, "volume could not be provisioned on this node")
+			continue
+		}
+		remaining = append(remaining, node)
+	}
+	return remaining
+}
+
+// selectCandidate applies the capacity, StatefulSet and topology constraints on nodes and picks one of the remaining ones with selector
+func selectCandidate(request SelectionRequest, selector NodeSelector, nodes []v1.Node, inFlight map[string]inFlightReservation, decision *PlacementDecision) (v1.Node, string, error) {
+	label := request.Selector
+	reservations, err := GetPendingReservations()
+	if err != nil {
+		return v1.Node{}, "", errors.New("Cannot calculate pending reservations, because: " + err.Error())
+	}
+	addInFlightReservations(reservations, inFlight)
+	if !request.ReservedCapacity.IsZero() {
+		for _, node := range nodes {
+			reserved := reservations[node.ObjectMeta.Name]
+			(&reserved).Add(request.ReservedCapacity)
+			reservations[node.ObjectMeta.Name] = reserved
+		}
+	}
+	candidates := filterNodesByCapacity(nodes, reservations, request.Size)
+	decision.exclude(nodes, candidates, "not enough free lv-capacity for "+request.Size.String())
+	if len(candidates) == 0 {
+		return v1.Node{}, "", errors.New("No node with enough free lv-capacity for " + request.Size.String() + " among nodes with label:" + label + "!")
+	}
+	reason := "only remaining candidate"
+	spreadNodes, err := applyStatefulSetPlacement(candidates, request.StatefulSetClaim, inFlight, request.Claim)
+	if err != nil {
+		return v1.Node{}, "", err
+	}
+	if request.StatefulSetClaim != nil {
+		replica := request.StatefulSetClaim.StatefulSet + "-" + strconv.Itoa(request.StatefulSetClaim.Ordinal)
+		decision.exclude(candidates, spreadNodes, "holds volumes of other replicas of "+request.StatefulSetClaim.StatefulSet)
+		if len(spreadNodes) == 1 && len(candidates) > 1 {
+			reason = "co-located with the other volumes of " + replica
+		}
+	}
+	candidates = spreadNodes
+	spreadNodes = spreadOverTopology(candidates, request.TopologyKeys, request.Group)
+	decision.exclude(candidates, spreadNodes, "topology domain is not the least loaded one of its spread group")
+	candidates = spreadNodes
+	scorer, _ := selector.(NodeScorer)
+	var scores map[string]float64
+	if scorer != nil {
+		scores = scorer.ScoreNodes(candidates)
+	}
+	for _, node := range candidates {
+		for i := range decision.Candidates {
+			if decision.Candidates[i].Name != node.ObjectMeta.Name {
+				continue
+			}
+			freeCapacity := node.Status.Capacity[LvCapacity]
+			decision.Candidates[i].FreeCapacity = freeCapacity.String()
+			if score, ok := scores[node.ObjectMeta.Name]; ok {
+				decision.Candidates[i].Score = &score
+			}
+		}
+	}
+	returnNode := candidates[0]
+	if len(candidates) > 1 {
+		returnNode, err = selector.SelectNode(request, candidates)
+		if err != nil {
+			return v1.Node{}, "", err
+		}
+		if returnNode.ObjectMeta.Name == "" {
+			return v1.Node{}, "", errors.New("No lv-capacity set, yet!")
+		}
+		reason = "chosen by the " + request.Method + " strategy"
+		if scorer != nil {
+			reason = "highest " + request.Method + " score"
+		}
+	}
+	return returnNode, reason, nil
+}
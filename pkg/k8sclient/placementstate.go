@@ -0,0 +1,300 @@
+package k8sclient
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	placementStateName    = "dlpp-placement-state"
+	roundRobinStateKey    = "roundRobin"
+	reservationsStateKey  = "reservations"
+	defaultStateNamespace = "kube-system"
+	stateNamespaceEnvName = "POD_NAMESPACE"
+	// inFlightReservationTTL is how long a reservation is kept for a claim which did not show up in the PVC cache
+	inFlightReservationTTL = 2 * time.Minute
+	// unnamedClaimPrefix starts the name in the reservation key of a claim created with generateName
+	unnamedClaimPrefix = "unnamed-"
+)
+
+// placementState is shared by the webhook replicas through a ConfigMap, updates rely on its resourceVersion
+type placementState struct {
+	// RoundRobin holds the last picked node per label selector
+	RoundRobin map[string]string
+	// Reservations hold the capacity of claims admitted, but possibly not yet visible in the PVC cache, by claim key
+	Reservations map[string]inFlightReservation
+	// local is set when the shared state could not be used, so the state of this replica only is known
+	local bool
+}
+
+type inFlightReservation struct {
+	Node    string            `json:"node"`
+	Size    resource.Quantity `json:"size"`
+	Expires metav1.Time       `json:"expires"`
+}
+
+// placementStateBackoff spreads the retries of replicas racing for the ConfigMap, e.g. during a StatefulSet scale-up,
+// staying well within the admission timeout
+var placementStateBackoff = wait.Backoff{
+	Steps:    8,
+	Duration: 20 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.5,
+	Cap:      time.Second,
+}
+
+var (
+	stateLock sync.Mutex
+	// lastState is used when the ConfigMap cannot be read, so a replica keeps working alone during API problems
+	lastState = placementState{RoundRobin: map[string]string{}, Reservations: map[string]inFlightReservation{}}
+)
+
+// ProvisionerNamespace is the namespace of the provisioner components, where they keep their shared state
+func ProvisionerNamespace() string {
+	if namespace := os.Getenv(stateNamespaceEnvName); namespace != "" {
+		return namespace
+	}
+	return defaultStateNamespace
+}
+
+// updatePlacementState runs update on the current shared state and stores the result if the state was not changed
+// meanwhile by another replica, otherwise update is run again on the fresh state. The state is not stored on dryRun.
+func updatePlacementState(dryRun bool, update func(state *placementState) error) error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	err := retry.OnError(placementStateBackoff, isStateConflict, func() error {
+		configMap, state, err := readPlacementState()
+		if err != nil {
+			log.Println("WARNING: Cannot read shared placement state, using the local one, because: " + err.Error())
+			configMap = nil
+			state = copyPlacementState(lastState)
+			state.local = true
+		}
+		if err = update(&state); err != nil {
+			return err
+		}
+		if dryRun {
+			return nil
+		}
+		pruneReservations(state.Reservations)
+		if configMap != nil {
+			if err = writePlacementState(configMap, state); isStateConflict(err) {
+				return err
+			}
+			if err != nil {
+				log.Println("WARNING: Cannot persist shared placement state, because: " + err.Error())
+			}
+		}
+		lastState = state
+		return nil
+	})
+	if !isStateConflict(err) {
+		return err
+	}
+	// the placement must not be denied because other replicas keep winning, the local state is used for this one
+	log.Println("WARNING: Shared placement state kept changing, placing with the local one, because: " + err.Error())
+	state := copyPlacementState(lastState)
+	state.local = true
+	if err = update(&state); err != nil {
+		return err
+	}
+	if !dryRun {
+		pruneReservations(state.Reservations)
+		lastState = state
+	}
+	return nil
+}
+
+func isStateConflict(err error) bool {
+	return k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err)
+}
+
+func readPlacementState() (*v1.ConfigMap, placementState, error) {
+	state := placementState{RoundRobin: map[string]string{}, Reservations: map[string]inFlightReservation{}}
+	clientSet, err := getClientSet()
+	if err != nil {
+		return nil, state, err
+	}
+	stateName := InstanceObjectName(placementStateName)
+	configMap, err := clientSet.CoreV1().ConfigMaps(ProvisionerNamespace()).Get(context.TODO(), stateName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: stateName}}, state, nil
+	}
+	if err != nil {
+		return nil, state, err
+	}
+	if data, ok := configMap.Data[roundRobinStateKey]; ok {
+		if err = json.Unmarshal([]byte(data), &state.RoundRobin); err != nil {
+			return nil, state, err
+		}
+	}
+	if data, ok := configMap.Data[reservationsStateKey]; ok {
+		if err = json.Unmarshal([]byte(data), &state.Reservations); err != nil {
+			return nil, state, err
+		}
+	}
+	return configMap, state, nil
+}
+
+// writePlacementState creates the ConfigMap, or updates it if it was read from the cluster, failing on concurrent changes
+func writePlacementState(configMap *v1.ConfigMap, state placementState) error {
+	clientSet, err := getClientSet()
+	if err != nil {
+		return err
+	}
+	roundRobin, err := json.Marshal(state.RoundRobin)
+	if err != nil {
+		return err
+	}
+	reservations, err := json.Marshal(state.Reservations)
+	if err != nil {
+		return err
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[roundRobinStateKey] = string(roundRobin)
+	configMap.Data[reservationsStateKey] = string(reservations)
+	configMaps := clientSet.CoreV1().ConfigMaps(ProvisionerNamespace())
+	if configMap.ObjectMeta.ResourceVersion == "" {
+		_, err = configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{})
+		return err
+	}
+	_, err = configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	return err
+}
+
+func copyPlacementState(state placementState) placementState {
+	stateCopy := placementState{RoundRobin: map[string]string{}, Reservations: map[string]inFlightReservation{}}
+	for selector, node := range state.RoundRobin {
+		stateCopy.RoundRobin[selector] = node
+	}
+	for claim, reservation := range state.Reservations {
+		stateCopy.Reservations[claim] = reservation
+	}
+	return stateCopy
+}
+
+// pruneReservations drops the expired reservations and the ones of claims already visible in the PVC cache,
+// which are counted by GetPendingReservations from then on
+func pruneReservations(reservations map[string]inFlightReservation) {
+	for claim, reservation := range reservations {
+		if time.Now().After(reservation.Expires.Time) || isClaimCached(claim) {
+			delete(reservations, claim)
+		}
+	}
+}
+
+// addInFlightReservations adds the reservations of the claims not yet counted by GetPendingReservations
+func addInFlightReservations(pending map[string]resource.Quantity, reservations map[string]inFlightReservation) {
+	for claim, reservation := range reservations {
+		if reservation.Node == "" || time.Now().After(reservation.Expires.Time) || isClaimCached(claim) {
+			continue
+		}
+		reserved := pending[reservation.Node]
+		(&reserved).Add(reservation.Size)
+		pending[reservation.Node] = reserved
+	}
+}
+
+// reserveInFlight reserves size on nodeName for claim.
+// An empty nodeName reserves the storage in the namespace of the claim only, before its node is known.
+func reserveInFlight(reservations map[string]inFlightReservation, claim string, nodeName string, size resource.Quantity) {
+	reservations[claim] = inFlightReservation{
+		Node:    nodeName,
+		Size:    size,
+		Expires: metav1.NewTime(time.Now().Add(inFlightReservationTTL)),
+	}
+}
+
+// ReleaseInFlightReservation drops the reservation of claim, e.g. because the admission of the claim was denied
+func ReleaseInFlightReservation(claim string) error {
+	return updatePlacementState(false, func(state *placementState) error {
+		delete(state.Reservations, claim)
+		return nil
+	})
+}
+
+// addNamespaceReservations adds the reservations of the claims of namespace which are not yet in the PVC cache to usage
+func addNamespaceReservations(usage *LocalStorageUsage, reservations map[string]inFlightReservation, namespace string) {
+	for claim, reservation := range reservations {
+		if !strings.HasPrefix(claim, namespace+"/") || time.Now().After(reservation.Expires.Time) || isClaimListed(claim) {
+			continue
+		}
+		usage.Claims++
+		(&usage.Storage).Add(reservation.Size)
+	}
+}
+
+// isClaimListed reports whether the claim with the namespace/name key is in the PVC cache, placed or not
+func isClaimListed(claim string) bool {
+	return getCachedClaim(claim) != nil
+}
+
+// isClaimCached reports whether the claim with the namespace/name key is already placed according to the PVC cache
+func isClaimCached(claim string) bool {
+	pvc := getCachedClaim(claim)
+	if pvc == nil {
+		return false
+	}
+	_, placed := pvc.ObjectMeta.Annotations[NodeName]
+	return placed
+}
+
+// getCachedClaim returns the claim with the namespace/name key from the PVC cache, claims admitted without name
+// are found by their ReservationKey annotation. The cached object must not be modified.
+func getCachedClaim(claim string) *v1.PersistentVolumeClaim {
+	cache := getListers()
+	if cache == nil {
+		return nil
+	}
+	keyParts := strings.SplitN(claim, "/", 2)
+	if len(keyParts) != 2 {
+		return nil
+	}
+	pvc, err := cache.pvcs.PersistentVolumeClaims(keyParts[0]).Get(keyParts[1])
+	if err == nil {
+		return pvc
+	}
+	if !strings.HasPrefix(keyParts[1], unnamedClaimPrefix) {
+		return nil
+	}
+	pvcs, err := cache.pvcs.PersistentVolumeClaims(keyParts[0]).List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	for _, pvc := range pvcs {
+		if pvc.ObjectMeta.Annotations[ReservationKey] == claim {
+			return pvc
+		}
+	}
+	return nil
+}
+
+// ClaimKey identifies a claim in the shared placement state, claims without name yet are identified by their namespace only
+func ClaimKey(pvc *v1.PersistentVolumeClaim) string {
+	return pvc.ObjectMeta.Namespace + "/" + pvc.ObjectMeta.Name
+}
+
+// ReservationClaimKey returns the key the claim reserves its storage under. Claims created with generateName
+// have no name at admission yet, they get a unique key, which they have to carry in their ReservationKey annotation.
+func ReservationClaimKey(pvc *v1.PersistentVolumeClaim) string {
+	if pvc.ObjectMeta.Name == "" {
+		return pvc.ObjectMeta.Namespace + "/" + unnamedClaimPrefix + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return ClaimKey(pvc)
+}
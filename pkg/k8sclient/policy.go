@@ -0,0 +1,194 @@
+package k8sclient
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// LocalStoragePolicyResource is the namespaced custom resource limiting the local storage of its namespace
+var LocalStoragePolicyResource = schema.GroupVersionResource{Group: "nokia.k8s.io", Version: "v1alpha1", Resource: "localstoragepolicies"}
+
+type LocalStoragePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              LocalStoragePolicySpec   `json:"spec"`
+	Status            LocalStoragePolicyStatus `json:"status,omitempty"`
+}
+
+type LocalStoragePolicySpec struct {
+	// Provisioner is the provisioner name of the instance enforcing the policy, the default instance if empty
+	Provisioner string `json:"provisioner,omitempty"`
+	// MaxStorage caps the sum of the storage requests of the local pvcs in the namespace
+	MaxStorage *resource.Quantity `json:"maxStorage,omitempty"`
+	// MaxClaims caps the number of local pvcs in the namespace
+	MaxClaims *int64 `json:"maxClaims,omitempty"`
+	// AllowedStorageClasses are the local storageclasses the pvcs may use, any if empty
+	AllowedStorageClasses []string `json:"allowedStorageClasses,omitempty"`
+	// AllowedNodePools are node selectors, the volumes are only placed on nodes matching one of them if any is given
+	AllowedNodePools []string `json:"allowedNodePools,omitempty"`
+}
+
+type LocalStoragePolicyStatus struct {
+	UsedStorage    resource.Quantity `json:"usedStorage"`
+	Claims         int64             `json:"claims"`
+	LastUpdateTime metav1.Time       `json:"lastUpdateTime,omitempty"`
+}
+
+// LocalStorageUsage is the local storage requested in a namespace
+type LocalStorageUsage struct {
+	Storage resource.Quantity
+	Claims  int64
+}
+
+var policyLister cache.GenericLister
+
+// WatchLocalStoragePolicies caches the LocalStoragePolicies, false is returned without error if their CRD is not installed
+func WatchLocalStoragePolicies(stopCh <-chan struct{}) (bool, error) {
+	client, err := getDynamicClient()
+	if err != nil {
+		return false, err
+	}
+	_, err = client.Resource(LocalStoragePolicyResource).List(context.TODO(), metav1.ListOptions{Limit: 1})
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, informerResyncPeriod)
+	informer := factory.ForResource(LocalStoragePolicyResource)
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.Informer().HasSynced) {
+		return false, errors.New("LocalStoragePolicy informer could not sync!")
+	}
+	listersLock.Lock()
+	policyLister = informer.Lister()
+	listersLock.Unlock()
+	return true, nil
+}
+
+// GetLocalStoragePolicies returns the policies of this instance in the namespace, or in every namespace if it is empty.
+// Nothing is returned while the policies are not watched.
+func GetLocalStoragePolicies(namespace string) ([]LocalStoragePolicy, error) {
+	listersLock.RLock()
+	lister := policyLister
+	listersLock.RUnlock()
+	if lister == nil {
+		return nil, nil
+	}
+	var (
+		objects []runtime.Object
+		err     error
+	)
+	if namespace == "" {
+		objects, err = lister.List(labels.Everything())
+	} else {
+		objects, err = lister.ByNamespace(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, err
+	}
+	var policies []LocalStoragePolicy
+	for _, object := range objects {
+		unstructuredPolicy, ok := object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		policy := LocalStoragePolicy{}
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredPolicy.UnstructuredContent(), &policy)
+		if err != nil {
+			log.Println("WARNING: Ignoring malformed LocalStoragePolicy " + unstructuredPolicy.GetNamespace() + "/" + unstructuredPolicy.GetName() + ", because: " + err.Error())
+			continue
+		}
+		if !policy.appliesToInstance() {
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// appliesToInstance reports whether the policy is enforced by this instance, each instance counts only its own pvcs
+func (policy LocalStoragePolicy) appliesToInstance() bool {
+	if policy.Spec.Provisioner == "" {
+		return LocalScProvisioner == DefaultProvisionerName
+	}
+	return policy.Spec.Provisioner == LocalScProvisioner
+}
+
+// ReserveLocalStorage checks the usage of the namespace of pvc with check, counting the claims admitted by any webhook replica
+// but not yet in the PVC cache as well, and reserves the storage of pvc if check found no problem, unless on dryRun.
+// The key the storage is reserved under is returned, so the placement of the claim takes the reservation over.
+// Without the shared state the usage of the other replicas is unknown, so the check fails then.
+func ReserveLocalStorage(pvc *v1.PersistentVolumeClaim, dryRun bool, check func(usage LocalStorageUsage) []string) (string, []string, error) {
+	claim := ReservationClaimKey(pvc)
+	cachedUsage, err := GetLocalStorageUsage(pvc.ObjectMeta.Namespace)
+	if err != nil {
+		return claim, nil, err
+	}
+	var problems []string
+	err = updatePlacementState(dryRun, func(state *placementState) error {
+		if state.local {
+			return errors.New("the claims admitted by the other webhook replicas are unknown")
+		}
+		usage := LocalStorageUsage{Storage: cachedUsage.Storage.DeepCopy(), Claims: cachedUsage.Claims}
+		addNamespaceReservations(&usage, state.Reservations, pvc.ObjectMeta.Namespace)
+		problems = check(usage)
+		if len(problems) == 0 {
+			reserveInFlight(state.Reservations, claim, "", pvc.Spec.Resources.Requests[v1.ResourceStorage])
+		}
+		return nil
+	})
+	return claim, problems, err
+}
+
+// GetLocalStorageUsage sums the storage requests of the local pvcs of the namespace
+func GetLocalStorageUsage(namespace string) (LocalStorageUsage, error) {
+	usage := LocalStorageUsage{}
+	pvcs, err := listPvcs()
+	if err != nil {
+		return usage, err
+	}
+	for _, pvc := range pvcs {
+		if pvc.ObjectMeta.Namespace != namespace {
+			continue
+		}
+		if isLocal, _ := PvcIsNokiaLocal(pvc); !isLocal {
+			continue
+		}
+		usage.Claims++
+		(&usage.Storage).Add(pvc.Spec.Resources.Requests[v1.ResourceStorage])
+	}
+	return usage, nil
+}
+
+// UpdateLocalStoragePolicyStatus reports the usage of the namespace in the status of the policy
+func UpdateLocalStoragePolicyStatus(policy LocalStoragePolicy, usage LocalStorageUsage) error {
+	client, err := getDynamicClient()
+	if err != nil {
+		return err
+	}
+	policy.Status = LocalStoragePolicyStatus{
+		UsedStorage:    usage.Storage,
+		Claims:         usage.Claims,
+		LastUpdateTime: metav1.Now(),
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&policy)
+	if err != nil {
+		return err
+	}
+	_, err = client.Resource(LocalStoragePolicyResource).Namespace(policy.ObjectMeta.Namespace).UpdateStatus(context.TODO(), &unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+	return err
+}
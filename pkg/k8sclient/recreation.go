@@ -0,0 +1,97 @@
+package k8sclient
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+const recreationsStateName = "dlpp-pending-recreations"
+
+// PendingRecreation is a pvc deleted to re-place it, which has to be created again once the deleted one is gone.
+// It is persisted before the deletion, so the claim is not lost when the replica deleting it stops.
+type PendingRecreation struct {
+	// DeletedUID is the UID of the deleted pvc, a pvc with another UID was created by someone else meanwhile
+	DeletedUID types.UID                 `json:"deletedUID"`
+	Claim      *v1.PersistentVolumeClaim `json:"claim"`
+}
+
+// SavePendingRecreation stores the recreation of the claim, replacing the previous one of the same claim
+func SavePendingRecreation(recreation PendingRecreation) error {
+	data, err := json.Marshal(recreation)
+	if err != nil {
+		return err
+	}
+	return updateRecreations(func(configMap *v1.ConfigMap) {
+		configMap.Data[recreationDataKey(ClaimKey(recreation.Claim))] = string(data)
+	})
+}
+
+// RemovePendingRecreation drops the recreation of the claim with the namespace/name key
+func RemovePendingRecreation(claim string) error {
+	return updateRecreations(func(configMap *v1.ConfigMap) {
+		delete(configMap.Data, recreationDataKey(claim))
+	})
+}
+
+// GetPendingRecreations returns the stored recreations by claim key
+func GetPendingRecreations() (map[string]PendingRecreation, error) {
+	recreations := make(map[string]PendingRecreation)
+	clientSet, err := getClientSet()
+	if err != nil {
+		return nil, err
+	}
+	configMap, err := clientSet.CoreV1().ConfigMaps(ProvisionerNamespace()).Get(context.TODO(), InstanceObjectName(recreationsStateName), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return recreations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range configMap.Data {
+		var recreation PendingRecreation
+		if err = json.Unmarshal([]byte(data), &recreation); err != nil || recreation.Claim == nil {
+			continue
+		}
+		recreations[ClaimKey(recreation.Claim)] = recreation
+	}
+	return recreations, nil
+}
+
+func updateRecreations(update func(configMap *v1.ConfigMap)) error {
+	clientSet, err := getClientSet()
+	if err != nil {
+		return err
+	}
+	configMaps := clientSet.CoreV1().ConfigMaps(ProvisionerNamespace())
+	stateName := InstanceObjectName(recreationsStateName)
+	return retry.OnError(retry.DefaultRetry, isStateConflict, func() error {
+		configMap, err := configMaps.Get(context.TODO(), stateName, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			configMap = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: stateName}, Data: map[string]string{}}
+			update(configMap)
+			_, err = configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		update(configMap)
+		_, err = configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// recreationDataKey turns the namespace/name key of a claim into a ConfigMap key, namespaces cannot contain dots
+func recreationDataKey(claim string) string {
+	return strings.Replace(claim, "/", ".", 1)
+}
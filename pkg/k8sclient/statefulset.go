@@ -0,0 +1,190 @@
+package k8sclient
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// StatefulSetClaim identifies a PVC created from a volumeClaimTemplate, named <template>-<statefulset>-<ordinal>
+type StatefulSetClaim struct {
+	Namespace   string
+	StatefulSet string
+	Template    string
+	Ordinal     int
+}
+
+var statefulSetLister appslisters.StatefulSetLister
+
+// WatchStatefulSets caches the StatefulSets, so PVCs are recognised as theirs without API calls.
+// The StatefulSets are listed first, so a missing permission is reported instead of the informer never syncing.
+func WatchStatefulSets(stopCh <-chan struct{}) error {
+	clientSet, err := getClientSet()
+	if err != nil {
+		return err
+	}
+	_, err = clientSet.AppsV1().StatefulSets(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return err
+	}
+	factory, err := InformerFactory()
+	if err != nil {
+		return err
+	}
+	statefulSetInformer := factory.Apps().V1().StatefulSets()
+	lister := statefulSetInformer.Lister()
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, statefulSetInformer.Informer().HasSynced) {
+		return errors.New("StatefulSet informer could not sync!")
+	}
+	listersLock.Lock()
+	statefulSetLister = lister
+	listersLock.Unlock()
+	return nil
+}
+
+// GetStatefulSetClaim returns the StatefulSet claim pvc was created for, or nil if it does not belong to a StatefulSet.
+// nil is returned as well while the StatefulSets are not watched.
+func GetStatefulSetClaim(pvc v1.PersistentVolumeClaim) (*StatefulSetClaim, error) {
+	ordinalIdx := strings.LastIndex(pvc.ObjectMeta.Name, "-")
+	if ordinalIdx < 0 {
+		return nil, nil
+	}
+	ordinal, err := strconv.Atoi(pvc.ObjectMeta.Name[ordinalIdx+1:])
+	if err != nil || ordinal < 0 {
+		return nil, nil
+	}
+	listersLock.RLock()
+	lister := statefulSetLister
+	listersLock.RUnlock()
+	if lister == nil {
+		return nil, nil
+	}
+	statefulSets, err := lister.StatefulSets(pvc.ObjectMeta.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	prefix := pvc.ObjectMeta.Name[:ordinalIdx]
+	for _, statefulSet := range statefulSets {
+		template := strings.TrimSuffix(prefix, "-"+statefulSet.ObjectMeta.Name)
+		if template == prefix || !hasClaimTemplate(statefulSet, template) {
+			continue
+		}
+		return &StatefulSetClaim{
+			Namespace:   pvc.ObjectMeta.Namespace,
+			StatefulSet: statefulSet.ObjectMeta.Name,
+			Template:    template,
+			Ordinal:     ordinal,
+		}, nil
+	}
+	return nil, nil
+}
+
+func hasClaimTemplate(statefulSet *appsv1.StatefulSet, template string) bool {
+	for _, claimTemplate := range statefulSet.Spec.VolumeClaimTemplates {
+		if claimTemplate.ObjectMeta.Name == template {
+			return true
+		}
+	}
+	return false
+}
+
+// applyStatefulSetPlacement keeps the claims of one ordinal together and the claims of different ordinals apart.
+// A node already holding a claim of the same ordinal is the only candidate, nodes holding other ordinals are avoided when possible.
+// The claims admitted but not yet in the PVC cache are taken from the in-flight reservations, except the own one of ownKey.
+func applyStatefulSetPlacement(candidates []v1.Node, claim *StatefulSetClaim, inFlight map[string]inFlightReservation, ownKey string) ([]v1.Node, error) {
+	if claim == nil {
+		return candidates, nil
+	}
+	pvcs, err := listPvcs()
+	if err != nil {
+		return nil, err
+	}
+	var colocationNode string
+	otherOrdinalNodes := make(map[string]bool)
+	addReplicaNode := func(pvcName string, nodeName string) {
+		ordinal, ok := statefulSetOrdinal(pvcName, claim)
+		if !ok {
+			return
+		}
+		if ordinal == claim.Ordinal {
+			colocationNode = nodeName
+		} else {
+			otherOrdinalNodes[nodeName] = true
+		}
+	}
+	for _, pvc := range pvcs {
+		nodeName, ok := pvc.ObjectMeta.Annotations[NodeName]
+		if !ok || pvc.ObjectMeta.Namespace != claim.Namespace {
+			continue
+		}
+		addReplicaNode(pvc.ObjectMeta.Name, nodeName)
+	}
+	for key, reservation := range inFlight {
+		if key == ownKey || reservation.Node == "" || !strings.HasPrefix(key, claim.Namespace+"/") ||
+			time.Now().After(reservation.Expires.Time) || isClaimCached(key) {
+			continue
+		}
+		addReplicaNode(strings.TrimPrefix(key, claim.Namespace+"/"), reservation.Node)
+	}
+	if colocationNode != "" {
+		for _, node := range candidates {
+			if node.ObjectMeta.Name == colocationNode {
+				return []v1.Node{node}, nil
+			}
+		}
+		return nil, errors.New("Cannot co-locate with the other volumes of " + claim.StatefulSet + "-" + strconv.Itoa(claim.Ordinal) + " on node " + colocationNode + ", it is not eligible or has not enough free lv-capacity!")
+	}
+	var spreadNodes []v1.Node
+	for _, node := range candidates {
+		if !otherOrdinalNodes[node.ObjectMeta.Name] {
+			spreadNodes = append(spreadNodes, node)
+		}
+	}
+	if len(spreadNodes) == 0 {
+		log.Println("WARNING: Every candidate node holds volumes of other replicas of " + claim.StatefulSet + ", spreading is not possible")
+		return candidates, nil
+	}
+	return spreadNodes, nil
+}
+
+// statefulSetOrdinal returns the ordinal of the claim named pvcName if it was created from a template of the StatefulSet of claim
+func statefulSetOrdinal(pvcName string, claim *StatefulSetClaim) (int, bool) {
+	for _, template := range claimTemplatesOf(claim) {
+		prefix := template + "-" + claim.StatefulSet + "-"
+		if !strings.HasPrefix(pvcName, prefix) {
+			continue
+		}
+		ordinal, err := strconv.Atoi(strings.TrimPrefix(pvcName, prefix))
+		if err == nil && ordinal >= 0 {
+			return ordinal, true
+		}
+	}
+	return 0, false
+}
+
+func claimTemplatesOf(claim *StatefulSetClaim) []string {
+	listersLock.RLock()
+	lister := statefulSetLister
+	listersLock.RUnlock()
+	if lister != nil {
+		if statefulSet, err := lister.StatefulSets(claim.Namespace).Get(claim.StatefulSet); err == nil {
+			var templates []string
+			for _, claimTemplate := range statefulSet.Spec.VolumeClaimTemplates {
+				templates = append(templates, claimTemplate.ObjectMeta.Name)
+			}
+			return templates
+		}
+	}
+	return []string{claim.Template}
+}
@@ -0,0 +1,135 @@
+package k8sclient
+
+import (
+	"log"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// TopologyKeysParameter is the StorageClass parameter listing the node labels, comma separated, whose domains volumes are spread over
+	TopologyKeysParameter = "topologyKeys"
+	// SpreadLabelParameter is the StorageClass parameter naming the PVC label which groups the volumes spread together.
+	// Without it PVCs are grouped by their controller owner, or by their StatefulSet.
+	SpreadLabelParameter = "spreadLabel"
+)
+
+// SpreadGroup identifies the PVCs whose volumes are spread over the topology domains together
+type SpreadGroup struct {
+	Namespace  string
+	OwnerUID   types.UID
+	LabelKey   string
+	LabelValue string
+	// StatefulSetClaim groups the claims of every ordinal of its StatefulSet
+	StatefulSetClaim *StatefulSetClaim
+}
+
+// NewSpreadGroup returns the group of pvc, or nil if it belongs to none
+func NewSpreadGroup(pvc v1.PersistentVolumeClaim, spreadLabel string) *SpreadGroup {
+	if spreadLabel != "" {
+		if value, ok := pvc.ObjectMeta.Labels[spreadLabel]; ok {
+			return &SpreadGroup{Namespace: pvc.ObjectMeta.Namespace, LabelKey: spreadLabel, LabelValue: value}
+		}
+	}
+	if owner := metav1.GetControllerOf(&pvc); owner != nil {
+		return &SpreadGroup{Namespace: pvc.ObjectMeta.Namespace, OwnerUID: owner.UID}
+	}
+	// claims created from volumeClaimTemplates have no controller owner
+	claim, err := GetStatefulSetClaim(pvc)
+	if err != nil {
+		log.Println("WARNING: Cannot check whether pvc " + pvc.ObjectMeta.Name + " belongs to a StatefulSet, because: " + err.Error())
+	}
+	if claim != nil {
+		return &SpreadGroup{Namespace: pvc.ObjectMeta.Namespace, StatefulSetClaim: claim}
+	}
+	return nil
+}
+
+func (group *SpreadGroup) contains(pvc *v1.PersistentVolumeClaim) bool {
+	if pvc.ObjectMeta.Namespace != group.Namespace {
+		return false
+	}
+	if group.LabelKey != "" {
+		value, ok := pvc.ObjectMeta.Labels[group.LabelKey]
+		return ok && value == group.LabelValue
+	}
+	if group.StatefulSetClaim != nil {
+		_, ok := statefulSetOrdinal(pvc.ObjectMeta.Name, group.StatefulSetClaim)
+		return ok
+	}
+	owner := metav1.GetControllerOf(pvc)
+	return owner != nil && owner.UID == group.OwnerUID
+}
+
+// spreadOverTopology narrows the candidates down to the least loaded domain of each topology key in turn,
+// the load of a domain being the number of volumes of the group already placed on its nodes
+func spreadOverTopology(candidates []v1.Node, topologyKeys []string, group *SpreadGroup) []v1.Node {
+	if len(topologyKeys) == 0 || group == nil {
+		return candidates
+	}
+	placedNodes, err := getGroupNodes(group)
+	if err != nil {
+		log.Println("WARNING: Cannot count volumes per topology domain, skipping spreading, because: " + err.Error())
+		return candidates
+	}
+	for _, topologyKey := range topologyKeys {
+		// nodes without the topology key belong to no domain and are not spread over
+		domainLoad := make(map[string]int)
+		for _, node := range candidates {
+			if domain, ok := node.ObjectMeta.Labels[topologyKey]; ok {
+				domainLoad[domain] = 0
+			}
+		}
+		if len(domainLoad) == 0 {
+			log.Println("WARNING: No candidate node has the topology key " + topologyKey + ", skipping spreading over it")
+			continue
+		}
+		for nodeName, volumes := range placedNodes {
+			node, err := GetNode(nodeName)
+			if err != nil {
+				continue
+			}
+			domain, ok := node.ObjectMeta.Labels[topologyKey]
+			if !ok {
+				continue
+			}
+			if _, ok := domainLoad[domain]; ok {
+				domainLoad[domain] += volumes
+			}
+		}
+		minLoad := -1
+		for _, load := range domainLoad {
+			if minLoad == -1 || load < minLoad {
+				minLoad = load
+			}
+		}
+		var leastLoaded []v1.Node
+		for _, node := range candidates {
+			domain, ok := node.ObjectMeta.Labels[topologyKey]
+			if ok && domainLoad[domain] == minLoad {
+				leastLoaded = append(leastLoaded, node)
+			}
+		}
+		candidates = leastLoaded
+	}
+	return candidates
+}
+
+// getGroupNodes returns the number of local volumes of the group per assigned node
+func getGroupNodes(group *SpreadGroup) (map[string]int, error) {
+	placedNodes := make(map[string]int)
+	pvcs, err := listPvcs()
+	if err != nil {
+		return nil, err
+	}
+	for _, pvc := range pvcs {
+		nodeName, ok := pvc.ObjectMeta.Annotations[NodeName]
+		if !ok || !group.contains(pvc) {
+			continue
+		}
+		placedNodes[nodeName]++
+	}
+	return placedNodes, nil
+}
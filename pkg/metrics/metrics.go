@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "dlpp"
+
+var (
+	admissionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "admission_duration_seconds",
+		Help:      "Latency of the admission requests per webhook endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"webhook"})
+	admissionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admission_errors_total",
+		Help:      "Admission requests per webhook endpoint which failed or were denied.",
+	}, []string{"webhook"})
+	placementDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "placement_decisions_total",
+		Help:      "Node selections per node selector strategy and result.",
+	}, []string{"strategy", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(admissionDuration, admissionErrors, placementDecisions)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveAdmission records an admission request served by webhook, which started at start
+func ObserveAdmission(webhook string, start time.Time, allowed bool) {
+	admissionDuration.WithLabelValues(webhook).Observe(time.Since(start).Seconds())
+	if !allowed {
+		admissionErrors.WithLabelValues(webhook).Inc()
+	}
+}
+
+// RecordPlacement counts a node selection made with strategy, err is the error of the selection if it failed
+func RecordPlacement(strategy string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	placementDecisions.WithLabelValues(strategy, result).Inc()
+}
@@ -0,0 +1,133 @@
+package mutator
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// serve decodes the AdmissionReview in the version the API server sent, admits its v1 form
+// and responds in the version of the request
+func serve(w http.ResponseWriter, r *http.Request, admit func(admissionv1.AdmissionReview) *admissionv1.AdmissionResponse) {
+	start := time.Now()
+	allowed := false
+	defer func() { metrics.ObserveAdmission(r.URL.Path, start, allowed) }()
+	var body []byte
+	if r.Body != nil {
+		if data, err := ioutil.ReadAll(r.Body); err == nil {
+			body = data
+		}
+	}
+	// verify the content type is accurate
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		log.Printf("ERROR: contentType=%s, expect application/json\n", contentType)
+		return
+	}
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(body, &typeMeta); err != nil {
+		log.Println("ERROR: Decode AdmissionReview type is failed, because " + err.Error())
+	}
+	deserializer := codecs.UniversalDeserializer()
+	var response interface{}
+	switch typeMeta.APIVersion {
+	case v1beta1.SchemeGroupVersion.String():
+		requestedAdmissionReview := v1beta1.AdmissionReview{}
+		responseAdmissionReview := v1beta1.AdmissionReview{TypeMeta: typeMeta}
+		if _, _, err := deserializer.Decode(body, nil, &requestedAdmissionReview); err != nil {
+			log.Println("ERROR: Decode AdmissionReview body is failed, because " + err.Error())
+			responseAdmissionReview.Response = toV1beta1Response(toAdmissionResponse(err))
+		} else {
+			review := admissionv1.AdmissionReview{}
+			if requestedAdmissionReview.Request != nil {
+				review.Request = toV1Request(requestedAdmissionReview.Request)
+			}
+			responseAdmissionReview.Response = toV1beta1Response(admitReview(review, admit))
+		}
+		allowed = responseAdmissionReview.Response.Allowed
+		response = responseAdmissionReview
+	default:
+		if typeMeta.APIVersion != admissionv1.SchemeGroupVersion.String() {
+			log.Println("WARNING: Unknown AdmissionReview version \"" + typeMeta.APIVersion + "\", answering with " + admissionv1.SchemeGroupVersion.String())
+			typeMeta = metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"}
+		}
+		requestedAdmissionReview := admissionv1.AdmissionReview{}
+		responseAdmissionReview := admissionv1.AdmissionReview{TypeMeta: typeMeta}
+		if _, _, err := deserializer.Decode(body, nil, &requestedAdmissionReview); err != nil {
+			log.Println("ERROR: Decode AdmissionReview body is failed, because " + err.Error())
+			responseAdmissionReview.Response = toAdmissionResponse(err)
+		} else {
+			responseAdmissionReview.Response = admitReview(requestedAdmissionReview, admit)
+		}
+		allowed = responseAdmissionReview.Response.Allowed
+		response = responseAdmissionReview
+	}
+
+	respBytes, err := json.Marshal(response)
+	if err != nil {
+		log.Println("ERROR: Marshal responseAdmissionReview is failed, because " + err.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(respBytes); err != nil {
+		log.Println("ERROR: Write response is failed, because " + err.Error())
+	}
+}
+
+func admitReview(review admissionv1.AdmissionReview, admit func(admissionv1.AdmissionReview) *admissionv1.AdmissionResponse) *admissionv1.AdmissionResponse {
+	if review.Request == nil {
+		return toAdmissionResponse(errors.New("ERROR: AdmissionReview has no request"))
+	}
+	response := admit(review)
+	response.UID = review.Request.UID
+	return response
+}
+
+// isDryRun reports whether the request must be admitted without side effects
+func isDryRun(ar admissionv1.AdmissionReview) bool {
+	return ar.Request.DryRun != nil && *ar.Request.DryRun
+}
+
+func toV1Request(request *v1beta1.AdmissionRequest) *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		UID:                request.UID,
+		Kind:               request.Kind,
+		Resource:           request.Resource,
+		SubResource:        request.SubResource,
+		RequestKind:        request.RequestKind,
+		RequestResource:    request.RequestResource,
+		RequestSubResource: request.RequestSubResource,
+		Name:               request.Name,
+		Namespace:          request.Namespace,
+		Operation:          admissionv1.Operation(request.Operation),
+		UserInfo:           request.UserInfo,
+		Object:             request.Object,
+		OldObject:          request.OldObject,
+		DryRun:             request.DryRun,
+		Options:            request.Options,
+	}
+}
+
+func toV1beta1Response(response *admissionv1.AdmissionResponse) *v1beta1.AdmissionResponse {
+	converted := &v1beta1.AdmissionResponse{
+		UID:              response.UID,
+		Allowed:          response.Allowed,
+		Result:           response.Result,
+		Patch:            response.Patch,
+		AuditAnnotations: response.AuditAnnotations,
+		Warnings:         response.Warnings,
+	}
+	if response.PatchType != nil {
+		patchType := v1beta1.PatchType(*response.PatchType)
+		converted.PatchType = &patchType
+	}
+	return converted
+}
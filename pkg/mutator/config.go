@@ -0,0 +1,142 @@
+package mutator
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	defaultConfigFilePath = "/etc/config/config.yml"
+	configReloadPeriod    = 10 * time.Second
+)
+
+// placementConfig is the content of the mounted configuration file, e.g.:
+//
+//	storageClasses:
+//	  local-fast:
+//	    defaultNodeSelector: "disktype in (ssd,nvme)"
+//	    nodeSelectorMethod: capacity
+//	namespaces:
+//	  tenant-a:
+//	    defaultNodeSelector: '{"pool":"tenant-a"}'
+//	    allowedNodePools: ["pool=tenant-a", "pool=shared"]
+//	reservedCapacity: 10Gi
+//
+// The legacy format, mapping storageclass names directly to their defaultNodeSelector, is still accepted.
+type placementConfig struct {
+	StorageClasses map[string]storageClassConfig `yaml:"storageClasses"`
+	Namespaces     map[string]namespaceConfig    `yaml:"namespaces"`
+	// ReservedCapacity is kept free in the pool of every node
+	ReservedCapacity string `yaml:"reservedCapacity"`
+
+	reservedCapacity resource.Quantity
+}
+
+type storageClassConfig struct {
+	DefaultNodeSelector string `yaml:"defaultNodeSelector"`
+	NodeSelectorMethod  string `yaml:"nodeSelectorMethod"`
+}
+
+type namespaceConfig struct {
+	DefaultNodeSelector string `yaml:"defaultNodeSelector"`
+	// AllowedNodePools are node selectors, volumes of the namespace are only placed on nodes matching one of them
+	AllowedNodePools []string `yaml:"allowedNodePools"`
+}
+
+var (
+	configLock       sync.RWMutex
+	activeConfig     = &placementConfig{}
+	activeConfigData []byte
+	configFilePath   = defaultConfigFilePath
+)
+
+func currentConfig() *placementConfig {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return activeConfig
+}
+
+// loadConfig activates the configuration file if it changed and is valid, otherwise the last good one stays active
+func loadConfig() error {
+	data, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return err
+	}
+	configLock.RLock()
+	unchanged := bytes.Equal(data, activeConfigData)
+	configLock.RUnlock()
+	if unchanged {
+		return nil
+	}
+	config, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+	configLock.Lock()
+	activeConfig = config
+	activeConfigData = data
+	configLock.Unlock()
+	log.Println("INFO: Placement configuration " + configFilePath + " loaded")
+	return nil
+}
+
+// WatchConfig reloads the configuration file periodically, picking up the updates of the mounted ConfigMap
+func WatchConfig(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := loadConfig(); err != nil && !os.IsNotExist(err) {
+			log.Println("ERROR: Cannot reload placement configuration, keeping the last good one, because: " + err.Error())
+		}
+	}, configReloadPeriod, stopCh)
+}
+
+func parseConfig(data []byte) (*placementConfig, error) {
+	config := &placementConfig{}
+	err := yaml.Unmarshal(data, config)
+	if err != nil || (config.StorageClasses == nil && config.Namespaces == nil && config.ReservedCapacity == "") {
+		legacyConfig := make(map[string]storageClassConfig)
+		if legacyErr := yaml.Unmarshal(data, &legacyConfig); legacyErr != nil {
+			if err != nil {
+				return nil, err
+			}
+			return nil, legacyErr
+		}
+		config = &placementConfig{StorageClasses: legacyConfig}
+	}
+	for storageClass, scConfig := range config.StorageClasses {
+		if _, err := parseNodeSelector(scConfig.DefaultNodeSelector); err != nil {
+			return nil, errors.New("invalid defaultNodeSelector of storageclass " + storageClass + ": " + err.Error())
+		}
+		if scConfig.NodeSelectorMethod != "" {
+			if _, err := k8sclient.GetNodeSelector(scConfig.NodeSelectorMethod); err != nil {
+				return nil, errors.New("invalid nodeSelectorMethod of storageclass " + storageClass + ": " + err.Error())
+			}
+		}
+	}
+	for namespace, nsConfig := range config.Namespaces {
+		if _, err := parseNodeSelector(nsConfig.DefaultNodeSelector); err != nil {
+			return nil, errors.New("invalid defaultNodeSelector of namespace " + namespace + ": " + err.Error())
+		}
+		for _, pool := range nsConfig.AllowedNodePools {
+			if _, err := parseNodeSelector(pool); err != nil {
+				return nil, errors.New("invalid allowedNodePools entry " + pool + " of namespace " + namespace + ": " + err.Error())
+			}
+		}
+	}
+	if config.ReservedCapacity != "" {
+		config.reservedCapacity, err = resource.ParseQuantity(config.ReservedCapacity)
+		if err != nil {
+			return nil, errors.New("invalid reservedCapacity: " + err.Error())
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,143 @@
+package mutator
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	placementDeferredReason = "PlacementDeferred"
+	waitingForNodeReason    = "WaitingForNode"
+	// DeferredPlacementLock is the Lease electing the replica which places the deferred pvcs
+	DeferredPlacementLock = "dlpp-deferred-placement"
+	deferredRetryPeriod   = 15 * time.Second
+)
+
+// DeferredPlacer places the local pvcs admitted without a node, once a node with enough capacity shows up
+type DeferredPlacer struct {
+	mutator *Mutator
+	trigger chan struct{}
+	// waiting holds the last reason recorded per pending pvc, so the retries do not flood the events
+	waiting map[types.UID]string
+}
+
+func NewDeferredPlacer(mutator *Mutator) *DeferredPlacer {
+	return &DeferredPlacer{mutator: mutator, trigger: make(chan struct{}, 1), waiting: make(map[types.UID]string)}
+}
+
+// Run retries the placements periodically and whenever a node changes, until stopCh is closed
+func (placer *DeferredPlacer) Run(stopCh <-chan struct{}) {
+	factory, err := k8sclient.InformerFactory()
+	if err != nil {
+		log.Println("ERROR: Cannot watch nodes for deferred placement, because: " + err.Error())
+	} else {
+		factory.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { placer.wakeUp() },
+			UpdateFunc: func(oldObj, newObj interface{}) { placer.wakeUp() },
+		})
+	}
+	go wait.Until(placer.wakeUp, deferredRetryPeriod, stopCh)
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-placer.trigger:
+			placer.placePending()
+		}
+	}
+}
+
+func (placer *DeferredPlacer) wakeUp() {
+	select {
+	case placer.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (placer *DeferredPlacer) placePending() {
+	pvcs, err := k8sclient.GetUnplacedPvcs()
+	if err != nil {
+		log.Println("ERROR: Cannot list unplaced pvcs, because: " + err.Error())
+		return
+	}
+	pending := make(map[types.UID]bool)
+	for _, pvc := range pvcs {
+		pending[pvc.ObjectMeta.UID] = true
+		placer.place(pvc)
+	}
+	for uid := range placer.waiting {
+		if !pending[uid] {
+			delete(placer.waiting, uid)
+		}
+	}
+}
+
+// recordWaiting records why pvc is still waiting for a node, unless the same reason was recorded last time
+func (placer *DeferredPlacer) recordWaiting(pvc *corev1.PersistentVolumeClaim, eventType string, message string) {
+	if placer.waiting[pvc.ObjectMeta.UID] == message {
+		return
+	}
+	placer.waiting[pvc.ObjectMeta.UID] = message
+	k8sclient.RecordPvcEvent(pvc, eventType, waitingForNodeReason, message)
+}
+
+func (placer *DeferredPlacer) place(pvc corev1.PersistentVolumeClaim) {
+	storageClass, err := resolveStorageClass(&pvc)
+	if err != nil || storageClass == nil || storageClass.Provisioner != k8sclient.LocalScProvisioner || k8sclient.IsWaitForFirstConsumer(storageClass) {
+		return
+	}
+	request, err := newSelectionRequest(pvc, storageClass, placer.mutator.selectMethod)
+	if err != nil {
+		placer.recordWaiting(&pvc, corev1.EventTypeWarning, err.Error())
+		return
+	}
+	node, decision, err := decidePlacement(pvc, request, placer.mutator.nodeLabel)
+	if err != nil {
+		placer.recordWaiting(&pvc, corev1.EventTypeNormal, "Waiting for a node to place the volume on: "+err.Error())
+		return
+	}
+	explanation, err := json.Marshal(decision)
+	if err != nil {
+		log.Println("WARNING: Cannot marshal placement explanation of pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+	}
+	pvDirName := k8sclient.GeneratePvDirName(pvc)
+	patchData := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				k8sclient.NodeName:             node.ObjectMeta.Name,
+				k8sclient.PvDirName:            pvDirName,
+				k8sclient.PlacementExplanation: string(explanation),
+			},
+		},
+		"spec": map[string]interface{}{
+			"volumeName": k8sclient.GeneratePVName(pvDirName, node.ObjectMeta.Name, storageClass.ObjectMeta.Name),
+		},
+	}
+	patchBytes, err := json.Marshal(patchData)
+	if err != nil {
+		log.Println("ERROR: Cannot marshal patch for pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+		return
+	}
+	clientSet, err := k8sclient.GetClientSet()
+	if err != nil {
+		log.Println("ERROR: Cannot place pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+		return
+	}
+	_, err = clientSet.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Patch(context.TODO(), pvc.ObjectMeta.Name, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+	if err != nil {
+		log.Println("ERROR: Cannot patch pvc " + pvc.ObjectMeta.Name + " with its deferred placement, because: " + err.Error())
+		return
+	}
+	delete(placer.waiting, pvc.ObjectMeta.UID)
+	log.Println("INFO: Deferred placement of pvc " + pvc.ObjectMeta.Namespace + "/" + pvc.ObjectMeta.Name + " on node " + node.ObjectMeta.Name)
+	k8sclient.RecordPvcEvent(&pvc, corev1.EventTypeNormal, placementDecidedReason, "Volume placed on node "+node.ObjectMeta.Name+": "+decision.Reason)
+}
@@ -0,0 +1,68 @@
+package mutator
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ServeExplain returns the placement decision of the posted pvc as if it was created now, without admitting it
+// or advancing the state of the node selector strategies
+func (mutator *Mutator) ServeExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "cannot read request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	pvc := corev1.PersistentVolumeClaim{}
+	if err := json.Unmarshal(body, &pvc); err != nil {
+		http.Error(w, "cannot decode pvc: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	decision, status := explainPvc(pvc, mutator.selectMethod, mutator.nodeLabel)
+	respBytes, err := json.Marshal(decision)
+	if err != nil {
+		log.Println("ERROR: Marshal placement decision is failed, because " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(respBytes); err != nil {
+		log.Println("ERROR: Write response is failed, because " + err.Error())
+	}
+}
+
+func explainPvc(pvc corev1.PersistentVolumeClaim, selectMethod string, nodeLabel string) (k8sclient.PlacementDecision, int) {
+	storageClass, err := resolveStorageClass(&pvc)
+	if err != nil {
+		return k8sclient.PlacementDecision{Reason: "cannot get storageclass of pvc: " + err.Error()}, http.StatusBadRequest
+	}
+	if storageClass == nil {
+		return k8sclient.PlacementDecision{Reason: "pvc has no storageclass and there is no default storageclass"}, http.StatusBadRequest
+	}
+	if storageClass.Provisioner != k8sclient.LocalScProvisioner {
+		return k8sclient.PlacementDecision{Reason: "storageclass " + storageClass.ObjectMeta.Name + " is not provisioned by " + k8sclient.LocalScProvisioner}, http.StatusOK
+	}
+	if nodeName, ok := pvc.ObjectMeta.Annotations[k8sclient.NodeName]; ok {
+		return k8sclient.PlacementDecision{Node: nodeName, Reason: "pinned by the " + k8sclient.NodeName + " annotation"}, http.StatusOK
+	}
+	if k8sclient.IsWaitForFirstConsumer(storageClass) {
+		return k8sclient.PlacementDecision{Reason: "storageclass " + storageClass.ObjectMeta.Name + " waits for the first consumer, the node is chosen by the scheduler"}, http.StatusOK
+	}
+	request, err := newSelectionRequest(pvc, storageClass, selectMethod)
+	if err != nil {
+		return k8sclient.PlacementDecision{Reason: err.Error()}, http.StatusBadRequest
+	}
+	request.DryRun = true
+	_, decision, _ := decidePlacement(pvc, request, nodeLabel)
+	return decision, http.StatusOK
+}
@@ -3,50 +3,37 @@ package mutator
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
-	"hash/fnv"
-	"io/ioutil"
 	"log"
-	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
-	"time"
 
-	"github.com/go-yaml/yaml"
-	"k8s.io/api/admission/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 
 	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
-	"github.com/sbabiv/roundrobin"
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/metrics"
 )
 
 const (
-	defaultSelectorFilePath = "/etc/config/config.yml"
-	nodeNameAnnotation      = "nokia.k8s.io/nodeName"
-	patchPvDirName          = "nokia.k8s.io~1pvDirName"
-	nodeSelector            = "nokia.k8s.io/nodeSelector"
+	placementDecidedReason = "PlacementDecided"
+	placementFailedReason  = "PlacementFailed"
+	nodeFullWarningRatio   = 0.9
 )
 
 var (
-	scheme                = runtime.NewScheme()
-	codecs                = serializer.NewCodecFactory(scheme)
-	parsedDefaultSelector map[string]struct {
-		DefaultNodeSelector string `yaml:"defaultNodeSelector"`
-	}
-	nodeSelectMethod string
+	scheme = runtime.NewScheme()
+	codecs = serializer.NewCodecFactory(scheme)
 )
 
-type patch struct {
-	Op    string          `json:"op"`
-	Path  string          `json:"path"`
-	Value json.RawMessage `json:"value"`
-}
-
-func toAdmissionResponse(err error) *v1beta1.AdmissionResponse {
-	return &v1beta1.AdmissionResponse{
+func toAdmissionResponse(err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
 		Result: &metav1.Status{
 			Message: err.Error(),
 		},
@@ -55,89 +42,37 @@ func toAdmissionResponse(err error) *v1beta1.AdmissionResponse {
 }
 
 type Mutator struct {
-	rr        *roundrobin.Balancer
-	nodeLabel string
+	selectMethod string
+	nodeLabel    string
+	// deferredPlacement admits the pvcs no node can be selected for yet, they are placed later by the DeferredPlacer
+	deferredPlacement bool
+	// trustedUsers may re-assign the node of an existing pvc, e.g. an operator fixing a placement by hand
+	trustedUsers map[string]bool
 }
 
-func NewMutator(method string, nodeLabel string) (*Mutator, error) {
-	var nodeList []string
-	err := parseDefaultNodeSelector()
-	if err != nil {
-		log.Println("WARNING: Cannot parse default node selector, because: " + err.Error() + ". Continue without it...")
+func NewMutator(method string, nodeLabel string, deferredPlacement bool, trustedUsers []string) (*Mutator, error) {
+	if _, err := k8sclient.GetNodeSelector(method); err != nil {
+		return nil, err
 	}
-	mutator := Mutator{rr: nil, nodeLabel: nodeLabel}
-	nodeSelectMethod = method
-	if nodeSelectMethod == k8sclient.RR {
-		nodes, err := k8sclient.GetAllNodes()
-		if err != nil {
-			return nil, errors.New("Cannot get list of all nodes, because: " + err.Error())
-		}
-		for _, node := range nodes.Items {
-			nodeList = append(nodeList, node.ObjectMeta.Name)
-		}
-		nodeIds := make([]interface{}, len(nodeList))
-		for i := 0; i < len(nodeList); i++ {
-			nodeIds[i] = i
-		}
-		mutator.rr = roundrobin.New(nodeIds)
-	}
-	return &mutator, nil
-}
-
-func parseDefaultNodeSelector() error {
-	file, err := ioutil.ReadFile(defaultSelectorFilePath)
+	err := loadConfig()
 	if err != nil {
-		return err
+		log.Println("WARNING: Cannot parse placement configuration, because: " + err.Error() + ". Continue without it...")
 	}
-	err = yaml.Unmarshal([]byte(file), &parsedDefaultSelector)
-	if err != nil {
-		return err
+	mutator := &Mutator{selectMethod: method, nodeLabel: nodeLabel, deferredPlacement: deferredPlacement, trustedUsers: map[string]bool{}}
+	for _, user := range trustedUsers {
+		mutator.trustedUsers[user] = true
 	}
-	return nil
+	return mutator, nil
 }
 
 func (mutator *Mutator) ServeMutatePvc(w http.ResponseWriter, r *http.Request) {
-	var body []byte
-	if r.Body != nil {
-		if data, err := ioutil.ReadAll(r.Body); err == nil {
-			body = data
-		}
-	}
-	// verify the content type is accurate
-	contentType := r.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		log.Printf("ERROR: contentType=%s, expect application/json\n", contentType)
-		return
-	}
-	requestedAdmissionReview := v1beta1.AdmissionReview{}
-
-	responseAdmissionReview := v1beta1.AdmissionReview{}
-
-	deserializer := codecs.UniversalDeserializer()
-	if _, _, err := deserializer.Decode(body, nil, &requestedAdmissionReview); err != nil {
-		log.Println("ERROR: Decode Pvc body is failed, because " + err.Error())
-		responseAdmissionReview.Response = toAdmissionResponse(err)
-	} else {
-		responseAdmissionReview.Response = mutatePvcs(requestedAdmissionReview, mutator.rr, mutator.nodeLabel)
-	}
-	responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
-
-	respBytes, err := json.Marshal(responseAdmissionReview)
-	if err != nil {
-		log.Println("ERROR: Marshal responseAdmissionReview is failed, because " + err.Error())
-	}
-	w.Header().Set("Content-Type", "application/json")
-
-	if _, err := w.Write(respBytes); err != nil {
-		log.Println("ERROR: Write response is failed, because " + err.Error())
-	}
+	serve(w, r, func(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+		return mutatePvcs(ar, mutator.selectMethod, mutator.nodeLabel, mutator.deferredPlacement)
+	})
 }
 
-func mutatePvcs(ar v1beta1.AdmissionReview, rr *roundrobin.Balancer, nodeLabel string) *v1beta1.AdmissionResponse {
-	var (
-		patchList []patch
-		err       error
-	)
+func mutatePvcs(ar admissionv1.AdmissionReview, selectMethod string, nodeLabel string, deferredPlacement bool) (response *admissionv1.AdmissionResponse) {
+	var err error
 	raw := ar.Request.Object.Raw
 	pvc := corev1.PersistentVolumeClaim{}
 	deserializer := codecs.UniversalDeserializer()
@@ -145,113 +80,261 @@ func mutatePvcs(ar v1beta1.AdmissionReview, rr *roundrobin.Balancer, nodeLabel s
 		log.Println("ERROR: Decode Pvc body is failed, because " + err.Error())
 		return toAdmissionResponse(err)
 	}
-	reviewResponse := v1beta1.AdmissionResponse{}
+	if pvc.ObjectMeta.Namespace == "" {
+		// the namespace of the request is not always set in the object yet, the policies of the namespace depend on it
+		pvc.ObjectMeta.Namespace = ar.Request.Namespace
+	}
+	reviewResponse := admissionv1.AdmissionResponse{}
 	reviewResponse.Allowed = true
 
-	mutatePvc, err := k8sclient.StorageClassIsNokiaLocal(*(pvc.Spec.StorageClassName))
-	if !mutatePvc {
-		if err != nil {
-			log.Println("ERROR: Cannot check storageclass " + pvc.ObjectMeta.Name + " pvc, ID: " + string(pvc.ObjectMeta.UID) + ", because " + err.Error())
-		}
+	classRequested := pvc.Spec.StorageClassName != nil
+	storageClass, err := resolveStorageClass(&pvc)
+	if err != nil {
+		log.Println("ERROR: Cannot check storageclass " + pvc.ObjectMeta.Name + " pvc, ID: " + string(pvc.ObjectMeta.UID) + ", because " + err.Error())
 		return &reviewResponse
 	}
+	if storageClass == nil || storageClass.Provisioner != k8sclient.LocalScProvisioner {
+		return &reviewResponse
+	}
+	claim, problems, err := reservePolicyUsage(pvc, storageClass, isDryRun(ar))
+	if err != nil {
+		// the limits of the namespace cannot be enforced without knowing its usage
+		log.Println("ERROR: Cannot check LocalStoragePolicies of pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+		return toAdmissionResponse(errors.New("ERROR: Cannot check LocalStoragePolicies of pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error()))
+	}
+	if len(problems) > 0 {
+		return toAdmissionResponse(errors.New("ERROR: Local pvc " + pvc.ObjectMeta.Name + " " + strings.Join(problems, "; ")))
+	}
+	if !isDryRun(ar) {
+		// the storage reserved for the namespace and the node is not used by a denied pvc
+		defer func() {
+			if response.Allowed {
+				return
+			}
+			if err := k8sclient.ReleaseInFlightReservation(claim); err != nil {
+				log.Println("WARNING: Cannot release the reservation of denied pvc " + pvc.ObjectMeta.Name + ", it expires later, because: " + err.Error())
+			}
+		}()
+	}
+	patches := newPatchBuilder(pvc.ObjectMeta)
+	if claim != k8sclient.ClaimKey(&pvc) {
+		// the name is generated after the admission, the reservation is matched with the pvc by this annotation
+		if err = patches.addAnnotation(k8sclient.ReservationKey, claim); err != nil {
+			return toAdmissionResponse(err)
+		}
+	}
+	if !classRequested {
+		// the default storageclass is recorded, so every component sees the same class even if the default changes later
+		if err = patches.add("/spec/storageClassName", storageClass.ObjectMeta.Name); err != nil {
+			return toAdmissionResponse(err)
+		}
+	}
 	nodeAnnotation, nodeAnnotationExists := pvc.ObjectMeta.Annotations[k8sclient.NodeName]
 	if !nodeAnnotationExists {
-		patchList, nodeAnnotation, err = setNodeSelector(pvc, patchList, rr, nodeLabel)
+		// node is chosen later by the scheduler, the executor of the selected node finishes the placement
+		if k8sclient.IsWaitForFirstConsumer(storageClass) {
+			return withPatches(&reviewResponse, patches)
+		}
+		request, err := newSelectionRequest(pvc, storageClass, selectMethod)
 		if err != nil {
 			return toAdmissionResponse(err)
 		}
-	}
-	patchList = patchVolumeNameAndPvDir(pvc, nodeAnnotation, patchList)
-
-	if len(patchList) > 0 {
-		patch, err := json.Marshal(patchList)
+		request.DryRun = isDryRun(ar)
+		request.Claim = claim
+		node, err := setNodeSelector(pvc, patches, request, nodeLabel)
+		if err != nil && deferredPlacement {
+			message := "no node can be selected yet, the volume is placed once one fits: " + strings.TrimPrefix(err.Error(), "ERROR: ")
+			if !request.DryRun {
+				k8sclient.RecordPvcEvent(&pvc, corev1.EventTypeNormal, placementDeferredReason, message)
+			}
+			reviewResponse.Warnings = append(reviewResponse.Warnings, message)
+			return withPatches(&reviewResponse, patches)
+		}
 		if err != nil {
-			log.Printf("ERROR: Patch marshall error %v:%v\n", patchList, err)
-			reviewResponse.Allowed = false
+			if !request.DryRun {
+				k8sclient.RecordPvcEvent(&pvc, corev1.EventTypeWarning, placementFailedReason, err.Error())
+			}
 			return toAdmissionResponse(err)
 		}
-		reviewResponse.Patch = []byte(patch)
-		pt := v1beta1.PatchTypeJSONPatch
-		reviewResponse.PatchType = &pt
+		nodeAnnotation = node.ObjectMeta.Name
+		if warning := nodeFullnessWarning(node, request.Size); warning != "" {
+			reviewResponse.Warnings = append(reviewResponse.Warnings, warning)
+		}
+	}
+	err = patchVolumeNameAndPvDir(pvc, nodeAnnotation, patches)
+	if err != nil {
+		log.Printf("ERROR: Patch marshall error %v:%v\n", patches.patches, err)
+		return toAdmissionResponse(err)
 	}
 
-	return &reviewResponse
+	return withPatches(&reviewResponse, patches)
 }
 
-func setNodeSelector(pvc corev1.PersistentVolumeClaim, patchList []patch, rr *roundrobin.Balancer, nodeLabel string) ([]patch, string, error) {
-	var patchItem patch
-	nodeSelectorMap := make(map[string]string)
-	if nodeSel, ok := pvc.ObjectMeta.Annotations[nodeSelector]; ok {
-		if nodeSel != "" {
-			err := json.Unmarshal([]byte(nodeSel), &nodeSelectorMap)
-			if err != nil {
-				return patchList, "", errors.New("ERROR: Cannot parse nodeselector " + nodeSel + " because: " + err.Error())
-			}
+// withPatches adds the patches to the response, if there is any
+func withPatches(reviewResponse *admissionv1.AdmissionResponse, patches *patchBuilder) *admissionv1.AdmissionResponse {
+	if patches.isEmpty() {
+		return reviewResponse
+	}
+	patch, err := patches.marshal()
+	if err != nil {
+		log.Printf("ERROR: Patch marshall error %v:%v\n", patches.patches, err)
+		return toAdmissionResponse(err)
+	}
+	reviewResponse.Patch = patch
+	pt := admissionv1.PatchTypeJSONPatch
+	reviewResponse.PatchType = &pt
+	return reviewResponse
+}
+
+// newSelectionRequest applies the placement settings of the storageclass parameters over the defaults
+func newSelectionRequest(pvc corev1.PersistentVolumeClaim, storageClass *storagev1.StorageClass, selectMethod string) (k8sclient.SelectionRequest, error) {
+	config := currentConfig()
+	request := k8sclient.SelectionRequest{
+		Method:           selectMethod,
+		Claim:            k8sclient.ClaimKey(&pvc),
+		Size:             pvc.Spec.Resources.Requests[corev1.ResourceStorage],
+		ReservedCapacity: config.reservedCapacity,
+	}
+	if method := config.StorageClasses[storageClass.ObjectMeta.Name].NodeSelectorMethod; method != "" {
+		request.Method = method
+	}
+	if method, ok := storageClass.Parameters[k8sclient.NodeSelectorMethodParameter]; ok {
+		request.Method = method
+	}
+	for _, pool := range config.Namespaces[pvc.ObjectMeta.Namespace].AllowedNodePools {
+		poolSelector, err := parseNodeSelector(pool)
+		if err != nil {
+			return request, errors.New("ERROR: Cannot parse allowed node pool " + pool + " because: " + err.Error())
 		}
+		request.AllowedNodePools = append(request.AllowedNodePools, poolSelector.String())
 	}
-	s := []string{}
-	if nodeLabel != "" {
-		s = append(s, nodeLabel)
+	requiredPools, err := policyNodePools(pvc.ObjectMeta.Namespace)
+	if err != nil {
+		return request, err
 	}
-	if len(nodeSelectorMap) > 0 {
-		for key, value := range nodeSelectorMap {
-			s = append(s, key+"="+value)
+	request.RequiredNodePools = requiredPools
+	if tolerations, ok := storageClass.Parameters[k8sclient.TolerationsParameter]; ok {
+		err := json.Unmarshal([]byte(tolerations), &request.Tolerations)
+		if err != nil {
+			return request, errors.New("ERROR: Cannot parse tolerations of storageclass " + storageClass.ObjectMeta.Name + " because: " + err.Error())
 		}
-	} else {
-		if scDefaultSelector, ok := parsedDefaultSelector[*pvc.Spec.StorageClassName]; ok {
-			for _, selector := range strings.Split(scDefaultSelector.DefaultNodeSelector, ",") {
-				key := strings.Trim(strings.Split(selector, ":")[0], "\"{}")
-				value := strings.Trim(strings.Split(selector, ":")[1], "\"{}")
-				s = append(s, key+"="+value)
-			}
+	}
+	if topologyKeys, ok := storageClass.Parameters[k8sclient.TopologyKeysParameter]; ok && topologyKeys != "" {
+		for _, topologyKey := range strings.Split(topologyKeys, ",") {
+			request.TopologyKeys = append(request.TopologyKeys, strings.TrimSpace(topologyKey))
 		}
+		request.Group = k8sclient.NewSpreadGroup(pvc, storageClass.Parameters[k8sclient.SpreadLabelParameter])
+	}
+	return request, nil
+}
+
+func setNodeSelector(pvc corev1.PersistentVolumeClaim, patches *patchBuilder, request k8sclient.SelectionRequest, nodeLabel string) (corev1.Node, error) {
+	node, decision, err := decidePlacement(pvc, request, nodeLabel)
+	if !request.DryRun {
+		metrics.RecordPlacement(request.Method, err)
+	}
+	explanation, marshalErr := json.Marshal(decision)
+	if marshalErr != nil {
+		log.Println("WARNING: Cannot marshal placement explanation of pvc " + pvc.ObjectMeta.Name + ", because: " + marshalErr.Error())
 	}
-	selector := strings.Join(s, ",")
-	node, err := k8sclient.GetNodeByLabel(selector, nodeSelectMethod, rr)
 	if err != nil {
-		return patchList, "", errors.New("ERROR: Cannot query node by label, because: " + err.Error())
+		return corev1.Node{}, errors.New("ERROR: Cannot query node by label, because: " + err.Error())
 	}
-	patchItem.Op = "add"
-	patchItem.Path = "/metadata/annotations"
-	patchItem.Value = json.RawMessage(`{"` + nodeNameAnnotation + `":"` + node.ObjectMeta.Name + `"}`)
-
-	patchList = append(patchList, patchItem)
-	return patchList, node.ObjectMeta.Name, nil
+	if !request.DryRun {
+		k8sclient.RecordPvcEvent(&pvc, corev1.EventTypeNormal, placementDecidedReason, "Volume placed on node "+node.ObjectMeta.Name+": "+decision.Reason)
+	}
+	if err := patches.addAnnotation(k8sclient.NodeName, node.ObjectMeta.Name); err != nil {
+		return corev1.Node{}, err
+	}
+	if err := patches.addAnnotation(k8sclient.PlacementExplanation, string(explanation)); err != nil {
+		return corev1.Node{}, err
+	}
+	return node, nil
 }
 
-func patchVolumeNameAndPvDir(pvc corev1.PersistentVolumeClaim, nodeName string, patchList []patch) []patch {
-	var patchItem patch
-	pvDirName := pvc.ObjectMeta.Namespace + "_" + pvc.ObjectMeta.Name + "-" + generateRandomSuffix(8)
-	volumeName := generatePVName(pvDirName, nodeName, *(pvc.Spec.StorageClassName))
-
-	patchItem.Op = "add"
-	patchItem.Path = "/metadata/annotations/" + patchPvDirName
-	patchItem.Value = json.RawMessage(`"` + pvDirName + `"`)
-	patchList = append(patchList, patchItem)
+// nodeFullnessWarning warns when the pool of the node becomes fuller than nodeFullWarningRatio with the new volume
+func nodeFullnessWarning(node corev1.Node, request resource.Quantity) string {
+	freeCapacity, capOk := node.Status.Capacity[k8sclient.LvCapacity]
+	poolSize, sizeOk := node.Status.Capacity[k8sclient.LvSize]
+	if !capOk || !sizeOk || poolSize.IsZero() {
+		return ""
+	}
+	usedRatio := 1 - float64(freeCapacity.Value()-request.Value())/float64(poolSize.Value())
+	if usedRatio <= nodeFullWarningRatio {
+		return ""
+	}
+	return "target node " + node.ObjectMeta.Name + " is above " + strconv.Itoa(int(nodeFullWarningRatio*100)) + "% full"
+}
 
-	patchItem.Path = "/spec/volumeName"
-	patchItem.Value = json.RawMessage(`"` + volumeName + `"`)
-	patchList = append(patchList, patchItem)
+// decidePlacement completes request with the node selector and StatefulSet membership of the pvc and selects its node
+func decidePlacement(pvc corev1.PersistentVolumeClaim, request k8sclient.SelectionRequest, nodeLabel string) (corev1.Node, k8sclient.PlacementDecision, error) {
+	var err error
+	request.Selector, err = buildNodeSelector(pvc, nodeLabel)
+	if err != nil {
+		return corev1.Node{}, k8sclient.PlacementDecision{Strategy: request.Method, Reason: err.Error()}, err
+	}
+	request.StatefulSetClaim, err = k8sclient.GetStatefulSetClaim(pvc)
+	if err != nil {
+		log.Println("WARNING: Cannot check whether pvc " + pvc.ObjectMeta.Name + " belongs to a StatefulSet, because: " + err.Error())
+	}
+	return k8sclient.GetNodeByLabel(request)
+}
 
-	return patchList
+// buildNodeSelector merges the global node label with the node selector of the pvc,
+// or if it has none, with the default of its namespace or of its storageclass, in this order
+func buildNodeSelector(pvc corev1.PersistentVolumeClaim, nodeLabel string) (string, error) {
+	config := currentConfig()
+	selector, err := labels.Parse(nodeLabel)
+	if err != nil {
+		return "", errors.New("ERROR: Cannot parse node label " + nodeLabel + " because: " + err.Error())
+	}
+	var pvcSelector labels.Selector
+	if nodeSel, ok := pvc.ObjectMeta.Annotations[k8sclient.NodeSelectorAnnotation]; ok && nodeSel != "" {
+		pvcSelector, err = parseNodeSelector(nodeSel)
+		if err != nil {
+			return "", errors.New("ERROR: Cannot parse nodeselector " + nodeSel + " of the " + k8sclient.NodeSelectorAnnotation + " annotation because: " + err.Error())
+		}
+	} else if nsConfig, ok := config.Namespaces[pvc.ObjectMeta.Namespace]; ok && nsConfig.DefaultNodeSelector != "" {
+		pvcSelector, err = parseNodeSelector(nsConfig.DefaultNodeSelector)
+		if err != nil {
+			return "", errors.New("ERROR: Cannot parse default nodeselector " + nsConfig.DefaultNodeSelector + " of namespace " + pvc.ObjectMeta.Namespace + " because: " + err.Error())
+		}
+	} else if scConfig, ok := config.StorageClasses[*pvc.Spec.StorageClassName]; ok {
+		pvcSelector, err = parseNodeSelector(scConfig.DefaultNodeSelector)
+		if err != nil {
+			return "", errors.New("ERROR: Cannot parse default nodeselector " + scConfig.DefaultNodeSelector + " of storageclass " + *pvc.Spec.StorageClassName + " because: " + err.Error())
+		}
+	}
+	if pvcSelector != nil {
+		requirements, _ := pvcSelector.Requirements()
+		selector = selector.Add(requirements...)
+	}
+	return selector.String(), nil
 }
 
-func generatePVName(file, node, class string) string {
-	h := fnv.New32a()
-	h.Write([]byte(file))
-	h.Write([]byte(node))
-	h.Write([]byte(class))
-	// This is the FNV-1a 32-bit hash
-	return fmt.Sprintf("local-pv-%x", h.Sum32())
+// resolveStorageClass returns the storageclass of the pvc, setting the default one in the pvc if it requested none.
+// nil is returned if the pvc has no storageclass at all.
+func resolveStorageClass(pvc *corev1.PersistentVolumeClaim) (*storagev1.StorageClass, error) {
+	storageClass, err := k8sclient.GetPvcStorageClass(pvc)
+	if err != nil || storageClass == nil {
+		return nil, err
+	}
+	pvc.Spec.StorageClassName = &storageClass.ObjectMeta.Name
+	return storageClass, nil
 }
 
-func generateRandomSuffix(suffixlength int) string {
-	charPool := []byte("abcdefghijklmnopqrstuvwxyz1234567890")
-	rand.Seed(time.Now().Unix())
-	bytes := make([]byte, suffixlength)
-	for i := range bytes {
-		bytes[i] = charPool[rand.Intn(len(charPool))]
-	}
-	return string(bytes)
+// patchVolumeNameAndPvDir pre-binds the pvc to the PV the local static provisioner creates for its directory on nodeName,
+// so the PV controller cannot bind the pvc to another available PV of the class in the meantime
+func patchVolumeNameAndPvDir(pvc corev1.PersistentVolumeClaim, nodeName string, patches *patchBuilder) error {
+	pvDirName, ok := pvc.ObjectMeta.Annotations[k8sclient.PvDirName]
+	if !ok {
+		pvDirName = k8sclient.GeneratePvDirName(pvc)
+		if err := patches.addAnnotation(k8sclient.PvDirName, pvDirName); err != nil {
+			return err
+		}
+	}
+	if pvc.Spec.VolumeName != "" {
+		return nil
+	}
+	return patches.add("/spec/volumeName", k8sclient.GeneratePVName(pvDirName, nodeName, *(pvc.Spec.StorageClassName)))
 }
@@ -0,0 +1,56 @@
+package mutator
+
+import (
+	"encoding/json"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// jsonPointerEscaper escapes a path segment according to RFC 6901, "~" has to be replaced first
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+type patch struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchBuilder collects the JSON patch operations of an object, the values are always marshalled, never concatenated
+type patchBuilder struct {
+	hasAnnotations bool
+	patches        []patch
+}
+
+func newPatchBuilder(meta metav1.ObjectMeta) *patchBuilder {
+	return &patchBuilder{hasAnnotations: meta.Annotations != nil}
+}
+
+// add sets the value at path, replacing the current value if there is any
+func (builder *patchBuilder) add(path string, value interface{}) error {
+	rawValue, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	builder.patches = append(builder.patches, patch{Op: "add", Path: path, Value: json.RawMessage(rawValue)})
+	return nil
+}
+
+// addAnnotation sets a single annotation, keeping the other annotations of the object
+func (builder *patchBuilder) addAnnotation(key string, value string) error {
+	if !builder.hasAnnotations {
+		if err := builder.add("/metadata/annotations", map[string]string{}); err != nil {
+			return err
+		}
+		builder.hasAnnotations = true
+	}
+	return builder.add("/metadata/annotations/"+jsonPointerEscaper.Replace(key), value)
+}
+
+func (builder *patchBuilder) isEmpty() bool {
+	return len(builder.patches) == 0
+}
+
+func (builder *patchBuilder) marshal() ([]byte, error) {
+	return json.Marshal(builder.patches)
+}
@@ -0,0 +1,112 @@
+package mutator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// applyPatch applies the patch of builder to the marshalled pvc, like the API server does
+func applyPatch(t *testing.T, pvc corev1.PersistentVolumeClaim, builder *patchBuilder) corev1.PersistentVolumeClaim {
+	t.Helper()
+	pvcBytes, err := json.Marshal(pvc)
+	if err != nil {
+		t.Fatalf("cannot marshal pvc: %v", err)
+	}
+	patchBytes, err := builder.marshal()
+	if err != nil {
+		t.Fatalf("cannot marshal patch: %v", err)
+	}
+	jsonPatch, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		t.Fatalf("cannot decode patch %s: %v", patchBytes, err)
+	}
+	patchedBytes, err := jsonPatch.Apply(pvcBytes)
+	if err != nil {
+		t.Fatalf("cannot apply patch %s: %v", patchBytes, err)
+	}
+	patched := corev1.PersistentVolumeClaim{}
+	if err = json.Unmarshal(patchedBytes, &patched); err != nil {
+		t.Fatalf("cannot unmarshal patched pvc: %v", err)
+	}
+	return patched
+}
+
+func TestAddAnnotationRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		add         map[string]string
+		expected    map[string]string
+	}{
+		{
+			name:     "pvc without annotations",
+			add:      map[string]string{"nokia.k8s.io/nodeName": "node-1"},
+			expected: map[string]string{"nokia.k8s.io/nodeName": "node-1"},
+		},
+		{
+			name:        "existing annotations are kept",
+			annotations: map[string]string{"nokia.k8s.io/nodeSelector": `{"zone":"a"}`, "owner": "team-a"},
+			add:         map[string]string{"nokia.k8s.io/nodeName": "node-1"},
+			expected:    map[string]string{"nokia.k8s.io/nodeSelector": `{"zone":"a"}`, "owner": "team-a", "nokia.k8s.io/nodeName": "node-1"},
+		},
+		{
+			name:     "keys with slash and tilde",
+			add:      map[string]string{"example.com/a~b/c": "x", "~/": "y"},
+			expected: map[string]string{"example.com/a~b/c": "x", "~/": "y"},
+		},
+		{
+			name:        "value with quotes",
+			annotations: map[string]string{"owner": "team-a"},
+			add:         map[string]string{"nokia.k8s.io/placement": `{"reason":"only \"remaining\" candidate"}`},
+			expected:    map[string]string{"owner": "team-a", "nokia.k8s.io/placement": `{"reason":"only \"remaining\" candidate"}`},
+		},
+		{
+			name:        "existing annotation is replaced",
+			annotations: map[string]string{"nokia.k8s.io/nodeName": "node-1"},
+			add:         map[string]string{"nokia.k8s.io/nodeName": "node-2"},
+			expected:    map[string]string{"nokia.k8s.io/nodeName": "node-2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pvc := corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "data-db-0", Namespace: "default", Annotations: test.annotations},
+			}
+			builder := newPatchBuilder(pvc.ObjectMeta)
+			for key, value := range test.add {
+				if err := builder.addAnnotation(key, value); err != nil {
+					t.Fatalf("cannot add annotation %s: %v", key, err)
+				}
+			}
+			patched := applyPatch(t, pvc, builder)
+			if !reflect.DeepEqual(patched.ObjectMeta.Annotations, test.expected) {
+				t.Errorf("annotations are %v, expected %v", patched.ObjectMeta.Annotations, test.expected)
+			}
+		})
+	}
+}
+
+func TestAddRoundTrip(t *testing.T) {
+	pvc := corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-db-0", Namespace: "default"}}
+	builder := newPatchBuilder(pvc.ObjectMeta)
+	storageClass := `local"class`
+	if err := builder.add("/spec/storageClassName", storageClass); err != nil {
+		t.Fatalf("cannot add storageClassName: %v", err)
+	}
+	patched := applyPatch(t, pvc, builder)
+	if patched.Spec.StorageClassName == nil || *patched.Spec.StorageClassName != storageClass {
+		t.Errorf("storageClassName is %v, expected %s", patched.Spec.StorageClassName, storageClass)
+	}
+}
+
+func TestEmptyPatchBuilder(t *testing.T) {
+	builder := newPatchBuilder(metav1.ObjectMeta{})
+	if !builder.isEmpty() {
+		t.Errorf("new patch builder is not empty")
+	}
+}
@@ -0,0 +1,113 @@
+package mutator
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func (mutator *Mutator) ServeMutatePod(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, mutatePods)
+}
+
+func mutatePods(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	raw := ar.Request.Object.Raw
+	pod := corev1.Pod{}
+	deserializer := codecs.UniversalDeserializer()
+	if _, _, err := deserializer.Decode(raw, nil, &pod); err != nil {
+		log.Println("ERROR: Decode Pod body is failed, because " + err.Error())
+		return toAdmissionResponse(err)
+	}
+	reviewResponse := admissionv1.AdmissionResponse{}
+	reviewResponse.Allowed = true
+
+	nodeName, err := getLocalVolumeNode(pod, ar.Request.Namespace)
+	if err != nil {
+		return toAdmissionResponse(err)
+	}
+	if nodeName == "" {
+		return &reviewResponse
+	}
+	affinity := pinToNode(pod.Spec.Affinity, nodeName)
+	patches := newPatchBuilder(pod.ObjectMeta)
+	if err := patches.add("/spec/affinity", affinity); err != nil {
+		log.Println("ERROR: Affinity marshall error: " + err.Error())
+		return toAdmissionResponse(err)
+	}
+	patchBytes, err := patches.marshal()
+	if err != nil {
+		log.Printf("ERROR: Patch marshall error %v:%v\n", patches.patches, err)
+		return toAdmissionResponse(err)
+	}
+	reviewResponse.Patch = patchBytes
+	pt := admissionv1.PatchTypeJSONPatch
+	reviewResponse.PatchType = &pt
+	return &reviewResponse
+}
+
+// getLocalVolumeNode returns the node all placed local PVCs of the pod are pinned to
+func getLocalVolumeNode(pod corev1.Pod, namespace string) (string, error) {
+	var nodeName string
+	if pod.ObjectMeta.Namespace != "" {
+		namespace = pod.ObjectMeta.Namespace
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := k8sclient.GetPvc(namespace, volume.PersistentVolumeClaim.ClaimName)
+		if k8serrors.IsNotFound(err) {
+			// the pvc may be created right before the pod, before the cache has seen it
+			pvc, err = k8sclient.GetPvcFromServer(namespace, volume.PersistentVolumeClaim.ClaimName)
+		}
+		if err != nil {
+			// the pod stays pending until the pvc is created, nothing to pin yet
+			log.Println("WARNING: Cannot get pvc " + volume.PersistentVolumeClaim.ClaimName + ", because: " + err.Error())
+			continue
+		}
+		pvcNodeName, ok := pvc.ObjectMeta.Annotations[k8sclient.NodeName]
+		if !ok {
+			continue
+		}
+		if isLocal, _ := k8sclient.PvcIsNokiaLocal(pvc); !isLocal {
+			continue
+		}
+		if nodeName != "" && nodeName != pvcNodeName {
+			return "", errors.New("ERROR: Local pvcs of the pod are placed on different nodes: " + nodeName + " and " + pvcNodeName)
+		}
+		nodeName = pvcNodeName
+	}
+	return nodeName, nil
+}
+
+// pinToNode adds a required node affinity for nodeName to every node selector term of the pod
+func pinToNode(affinity *corev1.Affinity, nodeName string) *corev1.Affinity {
+	requirement := corev1.NodeSelectorRequirement{
+		Key:      "metadata.name",
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{nodeName},
+	}
+	if affinity == nil {
+		affinity = &corev1.Affinity{}
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	if affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	nodeSelector := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(nodeSelector.NodeSelectorTerms) == 0 {
+		nodeSelector.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	// terms are ORed, so each of them has to carry the requirement
+	for i := range nodeSelector.NodeSelectorTerms {
+		nodeSelector.NodeSelectorTerms[i].MatchFields = append(nodeSelector.NodeSelectorTerms[i].MatchFields, requirement)
+	}
+	return affinity
+}
@@ -0,0 +1,162 @@
+package mutator
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// PolicyStatusLock is the Lease electing the replica which reports the usage in the LocalStoragePolicies
+	PolicyStatusLock    = "dlpp-policy-status"
+	policyStatusPeriod  = 30 * time.Second
+	policyViolationText = "violates LocalStoragePolicy "
+)
+
+// policyProblems checks a new local pvc against the LocalStoragePolicies of its namespace with the usage in the PVC cache,
+// the pvc itself is not part of the usage yet
+func policyProblems(pvc corev1.PersistentVolumeClaim, storageClass *storagev1.StorageClass) ([]string, error) {
+	policies, err := k8sclient.GetLocalStoragePolicies(pvc.ObjectMeta.Namespace)
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+	usage, err := k8sclient.GetLocalStorageUsage(pvc.ObjectMeta.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return policyViolations(pvc, storageClass, policies, usage), nil
+}
+
+// reservePolicyUsage checks a new local pvc against the LocalStoragePolicies of its namespace and reserves its storage,
+// so concurrently admitted pvcs cannot exceed the limits before they show up in the PVC cache.
+// The key of the reservation is returned, the placement of the pvc has to reserve its node under it.
+func reservePolicyUsage(pvc corev1.PersistentVolumeClaim, storageClass *storagev1.StorageClass, dryRun bool) (string, []string, error) {
+	claim := k8sclient.ReservationClaimKey(&pvc)
+	policies, err := k8sclient.GetLocalStoragePolicies(pvc.ObjectMeta.Namespace)
+	if err != nil || len(policies) == 0 {
+		return claim, nil, err
+	}
+	return k8sclient.ReserveLocalStorage(&pvc, dryRun, func(usage k8sclient.LocalStorageUsage) []string {
+		return policyViolations(pvc, storageClass, policies, usage)
+	})
+}
+
+func policyViolations(pvc corev1.PersistentVolumeClaim, storageClass *storagev1.StorageClass, policies []k8sclient.LocalStoragePolicy, usage k8sclient.LocalStorageUsage) []string {
+	storageRequest := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	requestedStorage := usage.Storage.DeepCopy()
+	(&requestedStorage).Add(storageRequest)
+	var problems []string
+	for _, policy := range policies {
+		violation := policyViolationText + policy.ObjectMeta.Name + ": "
+		spec := policy.Spec
+		if spec.MaxClaims != nil && usage.Claims+1 > *spec.MaxClaims {
+			problems = append(problems, violation+"at most "+strconv.FormatInt(*spec.MaxClaims, 10)+" local pvcs are allowed in the namespace")
+		}
+		if spec.MaxStorage != nil && requestedStorage.Cmp(*spec.MaxStorage) > 0 {
+			problems = append(problems, violation+"local storage of the namespace would be "+requestedStorage.String()+", at most "+spec.MaxStorage.String()+" is allowed")
+		}
+		if len(spec.AllowedStorageClasses) > 0 && !containsString(spec.AllowedStorageClasses, storageClass.ObjectMeta.Name) {
+			problems = append(problems, violation+"storageclass "+storageClass.ObjectMeta.Name+" is not allowed")
+		}
+		if nodeName, ok := pvc.ObjectMeta.Annotations[k8sclient.NodeName]; ok && len(spec.AllowedNodePools) > 0 {
+			node, err := k8sclient.GetNode(nodeName)
+			if err != nil {
+				continue
+			}
+			inPool, err := nodeInPools(*node, spec.AllowedNodePools)
+			if err != nil {
+				problems = append(problems, violation+"malformed allowed node pool: "+err.Error())
+			} else if !inPool {
+				problems = append(problems, violation+"node "+nodeName+" is outside the allowed node pools")
+			}
+		}
+	}
+	return problems
+}
+
+// policyNodePools returns the allowed node pools of every LocalStoragePolicy of the namespace restricting them
+func policyNodePools(namespace string) ([][]string, error) {
+	policies, err := k8sclient.GetLocalStoragePolicies(namespace)
+	if err != nil {
+		return nil, errors.New("ERROR: Cannot get LocalStoragePolicies of namespace " + namespace + " because: " + err.Error())
+	}
+	var requiredPools [][]string
+	for _, policy := range policies {
+		if len(policy.Spec.AllowedNodePools) == 0 {
+			continue
+		}
+		var pools []string
+		for _, pool := range policy.Spec.AllowedNodePools {
+			poolSelector, err := parseNodeSelector(pool)
+			if err != nil {
+				return nil, errors.New("ERROR: Cannot parse allowed node pool " + pool + " of LocalStoragePolicy " + policy.ObjectMeta.Name + " because: " + err.Error())
+			}
+			pools = append(pools, poolSelector.String())
+		}
+		requiredPools = append(requiredPools, pools)
+	}
+	return requiredPools, nil
+}
+
+func nodeInPools(node corev1.Node, pools []string) (bool, error) {
+	for _, pool := range pools {
+		poolSelector, err := parseNodeSelector(pool)
+		if err != nil {
+			return false, err
+		}
+		if poolSelector.Matches(labels.Set(node.ObjectMeta.Labels)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyStatusReporter keeps the usage of the namespaces up to date in the status of their LocalStoragePolicies
+type PolicyStatusReporter struct{}
+
+func NewPolicyStatusReporter() *PolicyStatusReporter {
+	return &PolicyStatusReporter{}
+}
+
+// Run reports the usage periodically, until stopCh is closed
+func (reporter *PolicyStatusReporter) Run(stopCh <-chan struct{}) {
+	wait.Until(reporter.report, policyStatusPeriod, stopCh)
+}
+
+func (reporter *PolicyStatusReporter) report() {
+	policies, err := k8sclient.GetLocalStoragePolicies("")
+	if err != nil {
+		log.Println("ERROR: Cannot list LocalStoragePolicies, because: " + err.Error())
+		return
+	}
+	for _, policy := range policies {
+		usage, err := k8sclient.GetLocalStorageUsage(policy.ObjectMeta.Namespace)
+		if err != nil {
+			log.Println("ERROR: Cannot compute local storage usage of namespace " + policy.ObjectMeta.Namespace + ", because: " + err.Error())
+			continue
+		}
+		if policy.Status.Claims == usage.Claims && policy.Status.UsedStorage.Cmp(usage.Storage) == 0 && !policy.Status.LastUpdateTime.IsZero() {
+			continue
+		}
+		err = k8sclient.UpdateLocalStoragePolicyStatus(policy, usage)
+		if err != nil {
+			log.Println("ERROR: Cannot update status of LocalStoragePolicy " + policy.ObjectMeta.Namespace + "/" + policy.ObjectMeta.Name + ", because: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package mutator
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	replacedReason           = "Replaced"
+	replacementPendingReason = "ReplacementPending"
+	// ReplacementLock is the Lease electing the replica which re-places the pvcs failed on their node
+	ReplacementLock   = "dlpp-replacement"
+	replacementPeriod = 30 * time.Second
+)
+
+// Replacer moves the unbound pvcs, whose provisioning failed on their assigned node for longer than timeout,
+// to another node selected the same way as by the webhook. As the pvcs are pre-bound to the PV of their node,
+// which cannot be changed, a failed pvc is deleted and created again with the new node assignment.
+// WaitForFirstConsumer pvcs are created again without node, so the scheduler selects one again.
+// The new pvcs are persisted before the deletion, so a new leader creates the ones its predecessor could not.
+type Replacer struct {
+	mutator *Mutator
+	timeout time.Duration
+	trigger chan struct{}
+	lock    sync.Mutex
+	// pending holds the claim keys of the persisted recreations, so only their deletion triggers a recreation
+	pending map[string]bool
+}
+
+func NewReplacer(mutator *Mutator, timeout time.Duration) *Replacer {
+	return &Replacer{mutator: mutator, timeout: timeout, trigger: make(chan struct{}, 1), pending: map[string]bool{}}
+}
+
+// Run recreates the pending pvcs whenever one of the deleted ones is gone, and periodically re-places the failed pvcs,
+// until stopCh is closed
+func (replacer *Replacer) Run(stopCh <-chan struct{}) {
+	factory, err := k8sclient.InformerFactory()
+	if err != nil {
+		log.Println("ERROR: Cannot watch pvc deletions, re-placed pvcs are only recreated periodically, because: " + err.Error())
+	} else {
+		factory.Core().V1().PersistentVolumeClaims().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			DeleteFunc: func(obj interface{}) {
+				claim, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+				if err == nil && replacer.isPending(claim) {
+					replacer.wakeUp()
+				}
+			},
+		})
+	}
+	ticker := time.NewTicker(replacementPeriod)
+	defer ticker.Stop()
+	// the recreations left behind by the previous leader are resumed first
+	replacer.recreatePending()
+	replacer.replaceFailed()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-replacer.trigger:
+			replacer.recreatePending()
+		case <-ticker.C:
+			replacer.recreatePending()
+			replacer.replaceFailed()
+		}
+	}
+}
+
+func (replacer *Replacer) wakeUp() {
+	select {
+	case replacer.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (replacer *Replacer) isPending(claim string) bool {
+	replacer.lock.Lock()
+	defer replacer.lock.Unlock()
+	return replacer.pending[claim]
+}
+
+func (replacer *Replacer) setPending(claim string, pending bool) {
+	replacer.lock.Lock()
+	defer replacer.lock.Unlock()
+	if pending {
+		replacer.pending[claim] = true
+	} else {
+		delete(replacer.pending, claim)
+	}
+}
+
+func (replacer *Replacer) replaceFailed() {
+	pvcs, err := k8sclient.GetFailedPvcs()
+	if err != nil {
+		log.Println("ERROR: Cannot list failed pvcs, because: " + err.Error())
+		return
+	}
+	for _, pvc := range pvcs {
+		failure := k8sclient.GetProvisioningFailure(&pvc)
+		if time.Since(failure.LastTransitionTime.Time) < replacer.timeout {
+			continue
+		}
+		replacer.replace(pvc)
+	}
+}
+
+// replace deletes the failed pvc once its new node is known, a pvc without a new node keeps its failure condition and is retried
+func (replacer *Replacer) replace(pvc corev1.PersistentVolumeClaim) {
+	storageClass, err := resolveStorageClass(&pvc)
+	if err != nil || storageClass == nil || storageClass.Provisioner != k8sclient.LocalScProvisioner {
+		return
+	}
+	failedNode := pvc.ObjectMeta.Annotations[k8sclient.NodeName]
+	newPvc := replacementClaim(pvc)
+	if !k8sclient.IsWaitForFirstConsumer(storageClass) {
+		request, err := newSelectionRequest(pvc, storageClass, replacer.mutator.selectMethod)
+		var (
+			node     corev1.Node
+			decision k8sclient.PlacementDecision
+		)
+		if err == nil {
+			request.ExcludedNodes = []string{failedNode}
+			node, decision, err = decidePlacement(pvc, request, replacer.mutator.nodeLabel)
+		}
+		if err != nil {
+			message := "Provisioning failed on node " + failedNode + ", no other node can be selected: " + err.Error()
+			log.Println("WARNING: Pvc " + pvc.ObjectMeta.Namespace + "/" + pvc.ObjectMeta.Name + ": " + message)
+			k8sclient.RecordPvcEvent(&pvc, corev1.EventTypeWarning, replacementPendingReason, message)
+			return
+		}
+		explanation, _ := json.Marshal(decision)
+		newPvc.ObjectMeta.Annotations[k8sclient.NodeName] = node.ObjectMeta.Name
+		newPvc.ObjectMeta.Annotations[k8sclient.PlacementExplanation] = string(explanation)
+	}
+	clientSet, err := k8sclient.GetClientSet()
+	if err != nil {
+		log.Println("ERROR: Cannot re-place pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+		return
+	}
+	claim := k8sclient.ClaimKey(&pvc)
+	recreation := k8sclient.PendingRecreation{DeletedUID: pvc.ObjectMeta.UID, Claim: newPvc}
+	// the pvc is only deleted once it can be recreated by any replica
+	if err = k8sclient.SavePendingRecreation(recreation); err != nil {
+		log.Println("ERROR: Cannot persist the recreation of pvc " + claim + ", it is not re-placed, because: " + err.Error())
+		return
+	}
+	replacer.setPending(claim, true)
+	// the pvc keeps its failure condition if it cannot be deleted, so it is retried in the next period
+	err = clientSet.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Delete(context.TODO(), pvc.ObjectMeta.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &pvc.ObjectMeta.UID},
+	})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		log.Println("ERROR: Cannot delete pvc " + claim + " for its re-placement, because: " + err.Error())
+		replacer.dropRecreation(claim)
+		return
+	}
+	log.Println("INFO: Pvc " + claim + " deleted to re-place it from node " + failedNode)
+	replacer.recreate(claim, recreation)
+}
+
+// recreatePending creates the persisted pvcs whose deleted pvc is gone
+func (replacer *Replacer) recreatePending() {
+	recreations, err := k8sclient.GetPendingRecreations()
+	if err != nil {
+		log.Println("ERROR: Cannot read the pvcs pending recreation, because: " + err.Error())
+		return
+	}
+	pending := make(map[string]bool)
+	for claim := range recreations {
+		pending[claim] = true
+	}
+	replacer.lock.Lock()
+	replacer.pending = pending
+	replacer.lock.Unlock()
+	for claim, recreation := range recreations {
+		replacer.recreate(claim, recreation)
+	}
+}
+
+// recreate creates the new pvc of claim if the deleted one is gone, otherwise its deletion triggers the recreation later
+func (replacer *Replacer) recreate(claim string, recreation k8sclient.PendingRecreation) {
+	newPvc := recreation.Claim
+	clientSet, err := k8sclient.GetClientSet()
+	if err != nil {
+		log.Println("ERROR: Cannot recreate pvc " + claim + ", because: " + err.Error())
+		return
+	}
+	pvcs := clientSet.CoreV1().PersistentVolumeClaims(newPvc.ObjectMeta.Namespace)
+	existing, err := pvcs.Get(context.TODO(), newPvc.ObjectMeta.Name, metav1.GetOptions{})
+	switch {
+	case err == nil && existing.ObjectMeta.UID == recreation.DeletedUID && existing.ObjectMeta.DeletionTimestamp == nil:
+		// the previous leader stopped before deleting it, the pvc is re-placed again while it keeps failing
+		replacer.dropRecreation(claim)
+		return
+	case err == nil && existing.ObjectMeta.UID == recreation.DeletedUID:
+		// e.g. a pod still uses it
+		return
+	case err == nil:
+		// e.g. the StatefulSet controller created it again, it is placed by the webhook as usual
+		replacer.dropRecreation(claim)
+		return
+	case !k8serrors.IsNotFound(err):
+		log.Println("ERROR: Cannot check whether pvc " + claim + " is deleted, retrying later, because: " + err.Error())
+		return
+	}
+	created, err := pvcs.Create(context.TODO(), newPvc, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		replacer.dropRecreation(claim)
+		return
+	}
+	if err != nil {
+		log.Println("ERROR: Cannot recreate pvc " + claim + ", retrying later, because: " + err.Error())
+		return
+	}
+	replacer.dropRecreation(claim)
+	message := "Claim recreated after the provisioning failed on its previous node, the scheduler selects a node again"
+	if nodeName, ok := newPvc.ObjectMeta.Annotations[k8sclient.NodeName]; ok {
+		message = "Claim recreated on node " + nodeName + " after the provisioning failed on its previous node"
+	}
+	log.Println("INFO: Pvc " + claim + ": " + message)
+	k8sclient.RecordPvcEvent(created, corev1.EventTypeNormal, replacedReason, message)
+}
+
+// dropRecreation forgets the recreation of claim, a failed removal is retried as the next recreation attempt finds the pvc existing
+func (replacer *Replacer) dropRecreation(claim string) {
+	replacer.setPending(claim, false)
+	if err := k8sclient.RemovePendingRecreation(claim); err != nil {
+		log.Println("WARNING: Cannot remove the recreation of pvc " + claim + ", because: " + err.Error())
+	}
+}
+
+// replacementClaim copies the pvc without its placement, binding and status, the webhook places and pre-binds the copy again
+func replacementClaim(pvc corev1.PersistentVolumeClaim) *corev1.PersistentVolumeClaim {
+	annotations := map[string]string{}
+	for key, value := range pvc.ObjectMeta.Annotations {
+		switch {
+		case key == k8sclient.NodeName, key == k8sclient.PvDirName, key == k8sclient.PlacementExplanation, key == k8sclient.SelectedNode, key == k8sclient.ReservationKey:
+			continue
+		case strings.HasPrefix(key, "pv.kubernetes.io/"), strings.HasSuffix(key, "/storage-provisioner"):
+			continue
+		}
+		annotations[key] = value
+	}
+	spec := *pvc.Spec.DeepCopy()
+	spec.VolumeName = ""
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pvc.ObjectMeta.Name,
+			Namespace:       pvc.ObjectMeta.Namespace,
+			Labels:          pvc.ObjectMeta.Labels,
+			Annotations:     annotations,
+			OwnerReferences: pvc.ObjectMeta.OwnerReferences,
+		},
+		Spec: spec,
+	}
+}
@@ -0,0 +1,66 @@
+package mutator
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// parseNodeSelector accepts a node selector in any of the supported formats:
+// a JSON label map, a JSON label selector with matchLabels and matchExpressions,
+// the Kubernetes label selector syntax (e.g. "zone in (a,b),!gpu"), or the legacy "key:value" pairs
+func parseNodeSelector(rawSelector string) (labels.Selector, error) {
+	rawSelector = strings.TrimSpace(rawSelector)
+	if rawSelector == "" {
+		return labels.Everything(), nil
+	}
+	if strings.HasPrefix(rawSelector, "{") {
+		return parseJSONNodeSelector(rawSelector)
+	}
+	selector, err := labels.Parse(rawSelector)
+	if err == nil {
+		return selector, nil
+	}
+	if strings.Contains(rawSelector, ":") && !strings.ContainsAny(rawSelector, "=!()") {
+		return parseLegacyNodeSelector(rawSelector)
+	}
+	return nil, err
+}
+
+func parseJSONNodeSelector(rawSelector string) (labels.Selector, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(rawSelector), &fields); err != nil {
+		return nil, err
+	}
+	_, hasMatchLabels := fields["matchLabels"]
+	_, hasMatchExpressions := fields["matchExpressions"]
+	if hasMatchLabels || hasMatchExpressions {
+		labelSelector := metav1.LabelSelector{}
+		decoder := json.NewDecoder(strings.NewReader(rawSelector))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&labelSelector); err != nil {
+			return nil, err
+		}
+		return metav1.LabelSelectorAsSelector(&labelSelector)
+	}
+	labelMap := make(map[string]string)
+	if err := json.Unmarshal([]byte(rawSelector), &labelMap); err != nil {
+		return nil, err
+	}
+	return labels.ValidatedSelectorFromSet(labelMap)
+}
+
+func parseLegacyNodeSelector(rawSelector string) (labels.Selector, error) {
+	labelMap := make(map[string]string)
+	for _, pair := range strings.Split(rawSelector, ",") {
+		keyValue := strings.SplitN(pair, ":", 2)
+		if len(keyValue) != 2 {
+			return nil, errors.New("missing ':' in \"" + pair + "\"")
+		}
+		labelMap[strings.Trim(keyValue[0], " \"{}")] = strings.Trim(keyValue[1], " \"{}")
+	}
+	return labels.ValidatedSelectorFromSet(labelMap)
+}
@@ -0,0 +1,148 @@
+package mutator
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/nokia/dynamic-local-pv-provisioner/pkg/k8sclient"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// immutableAnnotations can be set once, e.g. by the executor for WaitForFirstConsumer pvcs, but never changed afterwards,
+// except by the trusted users. The keys depend on the annotation domain, so they are only known after k8sclient.Configure.
+func immutableAnnotations() []string {
+	return []string{k8sclient.NodeName, k8sclient.PvDirName}
+}
+
+func (mutator *Mutator) ServeValidatePvc(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, func(ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+		return validatePvcs(ar, mutator.nodeLabel, mutator.deferredPlacement, mutator.trustedUsers)
+	})
+}
+
+func validatePvcs(ar admissionv1.AdmissionReview, nodeLabel string, deferredPlacement bool, trustedUsers map[string]bool) *admissionv1.AdmissionResponse {
+	var problems []string
+	pvc := corev1.PersistentVolumeClaim{}
+	deserializer := codecs.UniversalDeserializer()
+	if _, _, err := deserializer.Decode(ar.Request.Object.Raw, nil, &pvc); err != nil {
+		log.Println("ERROR: Decode Pvc body is failed, because " + err.Error())
+		return toAdmissionResponse(err)
+	}
+	if pvc.ObjectMeta.Namespace == "" {
+		// the namespace of the request is not always set in the object yet, the policies of the namespace depend on it
+		pvc.ObjectMeta.Namespace = ar.Request.Namespace
+	}
+	reviewResponse := admissionv1.AdmissionResponse{}
+	reviewResponse.Allowed = true
+	storageClass, err := resolveStorageClass(&pvc)
+	if err != nil {
+		log.Println("ERROR: Cannot check storageclass " + pvc.ObjectMeta.Name + " pvc, ID: " + string(pvc.ObjectMeta.UID) + ", because " + err.Error())
+		return &reviewResponse
+	}
+	if storageClass == nil || storageClass.Provisioner != k8sclient.LocalScProvisioner {
+		return &reviewResponse
+	}
+	switch ar.Request.Operation {
+	case admissionv1.Create:
+		problems = validateNewPvc(pvc, storageClass, nodeLabel, deferredPlacement)
+		violations, err := policyProblems(pvc, storageClass)
+		if err != nil {
+			log.Println("WARNING: Cannot check LocalStoragePolicies of pvc " + pvc.ObjectMeta.Name + ", because: " + err.Error())
+		}
+		problems = append(problems, violations...)
+	case admissionv1.Update:
+		oldPvc := corev1.PersistentVolumeClaim{}
+		if _, _, err := deserializer.Decode(ar.Request.OldObject.Raw, nil, &oldPvc); err != nil {
+			log.Println("ERROR: Decode old Pvc body is failed, because " + err.Error())
+			return toAdmissionResponse(err)
+		}
+		if trustedUsers[ar.Request.UserInfo.Username] {
+			return &reviewResponse
+		}
+		problems = validatePvcUpdate(oldPvc, pvc)
+	}
+	if len(problems) > 0 {
+		return toAdmissionResponse(errors.New("ERROR: Invalid local pvc " + pvc.ObjectMeta.Name + ": " + strings.Join(problems, "; ")))
+	}
+	return &reviewResponse
+}
+
+// validateNewPvc checks the new pvc, the capacity of the nodes is not judged with deferred placement as it may still change.
+// The capacity is judged over the nodes placement would consider, so the validator and the mutator agree.
+func validateNewPvc(pvc corev1.PersistentVolumeClaim, storageClass *storagev1.StorageClass, nodeLabel string, deferredPlacement bool) []string {
+	var problems []string
+	storageRequest, hasRequest := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if !hasRequest || storageRequest.IsZero() {
+		problems = append(problems, "storage request is mandatory")
+	}
+	for _, accessMode := range pvc.Spec.AccessModes {
+		if accessMode == corev1.ReadWriteMany {
+			problems = append(problems, "access mode "+string(accessMode)+" is not supported")
+		}
+	}
+	var eligibleNodes []corev1.Node
+	selector, err := buildNodeSelector(pvc, nodeLabel)
+	if err != nil {
+		problems = append(problems, "invalid node selector: "+strings.TrimPrefix(err.Error(), "ERROR: "))
+	}
+	if nodeName, ok := pvc.ObjectMeta.Annotations[k8sclient.NodeName]; ok {
+		node, err := k8sclient.GetNode(nodeName)
+		if err != nil {
+			problems = append(problems, "node "+nodeName+" does not exist")
+		} else {
+			eligibleNodes = append(eligibleNodes, *node)
+		}
+	} else if err == nil && !deferredPlacement {
+		nodeList, err := k8sclient.GetNodesByLabel(selector)
+		if err != nil {
+			log.Println("WARNING: Cannot list nodes for selector " + selector + ", skipping capacity validation, because: " + err.Error())
+			return problems
+		}
+		request, err := newSelectionRequest(pvc, storageClass, "")
+		if err != nil {
+			problems = append(problems, strings.TrimPrefix(err.Error(), "ERROR: "))
+			return problems
+		}
+		eligibleNodes, err = k8sclient.EligibleNodes(request, nodeList.Items)
+		if err != nil {
+			log.Println("WARNING: Cannot check eligibility of nodes for selector " + selector + ", skipping capacity validation, because: " + err.Error())
+			return problems
+		}
+	}
+	if hasRequest && !fitsAnyNode(storageRequest, eligibleNodes) {
+		problems = append(problems, "storage request "+storageRequest.String()+" is larger than the capacity of any eligible node")
+	}
+	return problems
+}
+
+// fitsAnyNode reports whether the request fits any of the nodes, nodes without published capacity are not judged
+func fitsAnyNode(storageRequest resource.Quantity, nodes []corev1.Node) bool {
+	capacityKnown := false
+	for _, node := range nodes {
+		nodeCapacity, ok := node.Status.Capacity[k8sclient.LvCapacity]
+		if !ok {
+			continue
+		}
+		capacityKnown = true
+		if (&nodeCapacity).Cmp(storageRequest) >= 0 {
+			return true
+		}
+	}
+	return !capacityKnown
+}
+
+func validatePvcUpdate(oldPvc corev1.PersistentVolumeClaim, newPvc corev1.PersistentVolumeClaim) []string {
+	var problems []string
+	for _, annotation := range immutableAnnotations() {
+		oldValue, ok := oldPvc.ObjectMeta.Annotations[annotation]
+		if ok && oldValue != newPvc.ObjectMeta.Annotations[annotation] {
+			problems = append(problems, annotation+" annotation cannot be changed")
+		}
+	}
+	return problems
+}